@@ -0,0 +1,379 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jxsl13/twapi/browser"
+)
+
+const (
+	alertsFile = "alerts.json"
+
+	// a server is down after defaultAlertFailures failed polls in a row
+	// and up again after defaultAlertRecoveries successful polls in a row.
+	defaultAlertFailures   = 3
+	defaultAlertRecoveries = 2
+	maxAlertThreshold      = 100
+)
+
+// LoadDowntimeAlerts reads the downtime alert settings from the data directory
+func LoadDowntimeAlerts() (*DowntimeAlerts, error) {
+	alerts := &DowntimeAlerts{Guilds: make(map[string]*DowntimeAlert)}
+	err := loadJSON(alertsFile, alerts)
+	if err != nil {
+		return nil, err
+	}
+	if alerts.Guilds == nil {
+		alerts.Guilds = make(map[string]*DowntimeAlert)
+	}
+	for _, a := range alerts.Guilds {
+		a.states = make(map[string]*downtimeState)
+	}
+	return alerts, nil
+}
+
+// DowntimeAlerts maps guild IDs to their downtime alert settings
+type DowntimeAlerts struct {
+	sync.Mutex
+	Guilds map[string]*DowntimeAlert `json:"guilds"`
+}
+
+// save must be called while holding the lock
+func (da *DowntimeAlerts) save() error {
+	return saveJSON(alertsFile, da)
+}
+
+// get must be called while holding the lock, it creates missing settings
+func (da *DowntimeAlerts) get(guildID string) *DowntimeAlert {
+	a, ok := da.Guilds[guildID]
+	if !ok {
+		a = &DowntimeAlert{states: make(map[string]*downtimeState)}
+		da.Guilds[guildID] = a
+	}
+	return a
+}
+
+var (
+	errAlertsUsage = errors.New("invalid arguments of the alerts command")
+)
+
+// Alert checks the registered servers of all guilds for downtimes and recoveries.
+// The alerts are sent after releasing the lock, so that slow channels do not block the poller or !alerts.
+func (da *DowntimeAlerts) Alert(s *discordgo.Session, infos []browser.ServerInfo, updatedAt time.Time) {
+	da.Lock()
+	messages := make([]downtimeMessage, 0)
+	for guildID, a := range da.Guilds {
+		messages = append(messages, a.Alert(config.Guilds.ServerList(guildID), infos, updatedAt)...)
+	}
+	da.Unlock()
+
+	for _, msg := range messages {
+		msg.send(s)
+	}
+}
+
+// Configure applies the subcommand of !alerts to the guild's settings and returns a copy of them.
+// The channel is validated by the caller, as that requires a request to discord.
+func (da *DowntimeAlerts) Configure(guildID, subcommand, argument, channelID string) (DowntimeAlert, error) {
+	da.Lock()
+	defer da.Unlock()
+
+	a := da.get(guildID)
+	switch subcommand {
+	case "":
+		return a.settings(), nil
+	case "channel":
+		a.ChannelID = channelID
+	case "role":
+		if strings.ToLower(argument) == "off" {
+			a.RoleID = ""
+			break
+		}
+		matches := roleMentionRegex.FindStringSubmatch(argument)
+		if len(matches) != 2 {
+			return DowntimeAlert{}, errors.New("invalid role, please mention the role like @role.")
+		}
+		a.RoleID = matches[1]
+	case "threshold":
+		fields := strings.Fields(argument)
+		if len(fields) < 1 || len(fields) > 2 {
+			return DowntimeAlert{}, errAlertsUsage
+		}
+		failures, err := parseAlertThreshold(fields[0])
+		if err != nil {
+			return DowntimeAlert{}, err
+		}
+		recoveries := a.recoveries()
+		if len(fields) == 2 {
+			recoveries, err = parseAlertThreshold(fields[1])
+			if err != nil {
+				return DowntimeAlert{}, err
+			}
+		}
+		a.Failures = failures
+		a.Recoveries = recoveries
+	default:
+		return DowntimeAlert{}, errAlertsUsage
+	}
+
+	err := da.save()
+	if err != nil {
+		log.Printf("failed to save the alert settings: %v\n", err)
+		return DowntimeAlert{}, errors.New("failed to save the alert settings.")
+	}
+	return a.settings(), nil
+}
+
+// downtimeState tracks the consecutive poll results of a single server
+type downtimeState struct {
+	name       string
+	failures   int
+	recoveries int
+	down       bool
+	downSince  time.Time
+}
+
+// DowntimeAlert posts a message into a channel when a server stops responding and when it recovers.
+// The thresholds are a hysteresis that prevents flapping servers from spamming the channel.
+type DowntimeAlert struct {
+	ChannelID string `json:"channel_id"`
+	// RoleID is mentioned in downtime alerts if set
+	RoleID     string `json:"role_id,omitempty"`
+	Failures   int    `json:"failures,omitempty"`
+	Recoveries int    `json:"recoveries,omitempty"`
+
+	// keyed by resolved ip:port
+	states map[string]*downtimeState
+}
+
+// settings returns a copy of the settings without the states of the servers
+func (a *DowntimeAlert) settings() DowntimeAlert {
+	return DowntimeAlert{
+		ChannelID:  a.ChannelID,
+		RoleID:     a.RoleID,
+		Failures:   a.Failures,
+		Recoveries: a.Recoveries,
+	}
+}
+
+func (a *DowntimeAlert) failures() int {
+	if a.Failures <= 0 {
+		return defaultAlertFailures
+	}
+	return a.Failures
+}
+
+func (a *DowntimeAlert) recoveries() int {
+	if a.Recoveries <= 0 {
+		return defaultAlertRecoveries
+	}
+	return a.Recoveries
+}
+
+// Alert updates the states of the guild's servers with the polled infos, registered servers without info count as failed.
+// It returns the alerts that need to be sent.
+func (a *DowntimeAlert) Alert(list *ConcurrentServerList, infos []browser.ServerInfo, updatedAt time.Time) []downtimeMessage {
+	if a.ChannelID == "" {
+		return nil
+	}
+
+	fetched := make(map[string]browser.ServerInfo, len(infos))
+	for _, info := range infos {
+		if info.Name != "" {
+			fetched[info.Address] = info
+		}
+	}
+
+	down := strings.Builder{}
+	up := strings.Builder{}
+	polled := make(map[string]bool)
+
+	for _, entry := range list.Entries() {
+		for _, addr := range entry.Addrs {
+			address := addr.String()
+			polled[address] = true
+
+			state, ok := a.states[address]
+			if !ok {
+				state = &downtimeState{}
+				a.states[address] = state
+			}
+
+			label := entry.Label()
+			if len(entry.Addrs) > 1 {
+				label = fmt.Sprintf("%s [%s]", label, address)
+			}
+
+			info, reachable := fetched[address]
+			if reachable {
+				state.name = info.Name
+				state.failures = 0
+				state.recoveries++
+
+				if state.down && state.recoveries >= a.recoveries() {
+					state.down = false
+					up.WriteString(fmt.Sprintf("%s is responding again after %s of downtime.\n",
+						formatAlertServer(state.name, label), formatDuration(updatedAt.Sub(state.downSince))))
+				}
+				continue
+			}
+
+			// the downtime continues if the server did not recover long enough
+			if state.failures == 0 && !state.down {
+				state.downSince = updatedAt
+			}
+			state.failures++
+			state.recoveries = 0
+
+			if !state.down && state.failures >= a.failures() {
+				state.down = true
+				down.WriteString(fmt.Sprintf("%s has not been responding for %s (%d failed polls).\n",
+					formatAlertServer(state.name, label), formatDuration(updatedAt.Sub(state.downSince)), state.failures))
+			}
+		}
+	}
+
+	// forget servers that are not registered anymore
+	for address := range a.states {
+		if !polled[address] {
+			delete(a.states, address)
+		}
+	}
+
+	messages := make([]downtimeMessage, 0, 2)
+	if down.Len() > 0 {
+		messages = append(messages, downtimeMessage{channelID: a.ChannelID, roleID: a.RoleID, text: down.String()})
+	}
+	if up.Len() > 0 {
+		messages = append(messages, downtimeMessage{channelID: a.ChannelID, text: up.String()})
+	}
+	return messages
+}
+
+// downtimeMessage is an alert that is posted into the alert channel
+type downtimeMessage struct {
+	channelID string
+	// roleID is mentioned if set
+	roleID string
+	text   string
+}
+
+// send posts the text into the alert channel, the role is only mentioned in the first message
+func (msg downtimeMessage) send(s *discordgo.Session) {
+	w := NewOutputWriter()
+	if msg.roleID != "" {
+		w.WriteLine(fmt.Sprintf("<@&%s>", msg.roleID))
+	}
+	w.Write(msg.text)
+
+	for idx, content := range w.Messages() {
+		data := &discordgo.MessageSend{
+			Content:         content,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		}
+		if msg.roleID != "" && idx == 0 {
+			data.AllowedMentions.Roles = []string{msg.roleID}
+		}
+
+		_, err := s.ChannelMessageSendComplex(msg.channelID, data)
+		if err != nil {
+			log.Printf("failed to send downtime alert: %v\n", err)
+			return
+		}
+	}
+}
+
+// formatAlertServer formats the last known server name and the label of the registered server
+func formatAlertServer(name, label string) string {
+	if name == "" {
+		return fmt.Sprintf("**%s**", Escape(label))
+	}
+	return fmt.Sprintf("**%s** (%s)", Escape(name), Escape(label))
+}
+
+// formatDuration returns a short human readable duration like 1h05m
+func formatDuration(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm%02ds", int(d.Minutes()), int(d.Seconds())%60)
+	default:
+		return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+	}
+}
+
+// parseAlertThreshold parses a number of polls
+func parseAlertThreshold(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > maxAlertThreshold {
+		return 0, fmt.Errorf("the number of polls must be between 1 and %d", maxAlertThreshold)
+	}
+	return n, nil
+}
+
+// AlertsHandler handles the !alerts command that configures downtime alerts.
+func AlertsHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
+	ss := strings.SplitN(strings.TrimSpace(args), " ", 2)
+	subcommand := strings.ToLower(ss[0])
+	argument := ""
+	if len(ss) > 1 {
+		argument = strings.TrimSpace(ss[1])
+	}
+
+	// the channel is validated before locking the settings, as that requires a request to discord
+	channelID := ""
+	if subcommand == "channel" {
+		var err error
+		channelID, err = mentionedChannel(s, m, argument)
+		if err != nil {
+			respond(s, m, err.Error())
+			return
+		}
+	}
+
+	a, err := config.DowntimeAlerts.Configure(m.GuildID, subcommand, argument, channelID)
+	if err == errAlertsUsage {
+		respond(s, m, usage(m, "alerts"))
+		return
+	} else if err != nil {
+		respond(s, m, err.Error())
+		return
+	}
+
+	reply := ""
+	switch subcommand {
+	case "":
+		if a.ChannelID == "" {
+			reply = "Downtime alerts are disabled."
+		} else {
+			reply = fmt.Sprintf("Downtime alerts are posted to <#%s>.", a.ChannelID)
+		}
+		if a.RoleID != "" {
+			reply += fmt.Sprintf("\nAlerts mention the role %s.", roleName(s, m.GuildID, a.RoleID))
+		}
+		reply += fmt.Sprintf("\nServers are down after %d failed polls and up again after %d successful polls.", a.failures(), a.recoveries())
+	case "channel":
+		if a.ChannelID == "" {
+			reply = "Downtime alerts disabled."
+		} else {
+			reply = fmt.Sprintf("Downtime alerts are posted to <#%s>.", a.ChannelID)
+		}
+	case "role":
+		if a.RoleID == "" {
+			reply = "Downtime alerts do not mention a role anymore."
+		} else {
+			reply = fmt.Sprintf("Downtime alerts mention the role %s.", roleName(s, m.GuildID, a.RoleID))
+		}
+	case "threshold":
+		reply = fmt.Sprintf("Servers are down after %d failed polls and up again after %d successful polls.", a.failures(), a.recoveries())
+	}
+	respond(s, m, reply)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jxsl13/twapi/browser"
+)
+
+func TestDowntimeAlertThresholds(t *testing.T) {
+	_, restore := withTestConfig(t, "1.1.1.1:8303\n")
+	defer restore()
+
+	list := config.Guilds.ServerList("")
+	a := &DowntimeAlert{ChannelID: "5", RoleID: "6", Failures: 2, Recoveries: 2, states: make(map[string]*downtimeState)}
+	reachable := []browser.ServerInfo{{Address: "1.1.1.1:8303", Name: "server"}}
+	now := time.Now()
+
+	if messages := a.Alert(list, reachable, now); len(messages) != 0 {
+		t.Fatalf("expected no alert for a reachable server, got %v", messages)
+	}
+	if messages := a.Alert(list, nil, now); len(messages) != 0 {
+		t.Fatalf("expected no alert before the failure threshold, got %v", messages)
+	}
+
+	messages := a.Alert(list, nil, now.Add(time.Minute))
+	if len(messages) != 1 || messages[0].channelID != "5" || messages[0].roleID != "6" {
+		t.Fatalf("expected a downtime alert that mentions the role, got %v", messages)
+	}
+
+	if messages := a.Alert(list, reachable, now); len(messages) != 0 {
+		t.Fatalf("expected no alert before the recovery threshold, got %v", messages)
+	}
+	messages = a.Alert(list, reachable, now)
+	if len(messages) != 1 || messages[0].roleID != "" {
+		t.Fatalf("expected a recovery alert without a role mention, got %v", messages)
+	}
+}
+
+func TestDowntimeAlertsSendWithoutLock(t *testing.T) {
+	_, restore := withTestConfig(t, "1.1.1.1:8303\n")
+	defer restore()
+
+	da := &DowntimeAlerts{Guilds: map[string]*DowntimeAlert{
+		"": {ChannelID: "5", RoleID: "6", Failures: 1, states: make(map[string]*downtimeState)},
+	}}
+	config.DowntimeAlerts = da
+
+	s, rt := newRecordingSession(t)
+	rt.onRequest = func() {
+		done := make(chan struct{})
+		go func() {
+			da.Configure("", "", "", "")
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Error("expected the alert settings not to be locked while sending alerts")
+		}
+	}
+
+	da.Alert(s, nil, time.Now())
+
+	requests := rt.Requests()
+	assertRequests(t, requests, "POST /channels/5/messages")
+	msg := discordgo.MessageSend{}
+	err := json.Unmarshal([]byte(requests[0].Body), &msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.AllowedMentions == nil || len(msg.AllowedMentions.Roles) != 1 || msg.AllowedMentions.Roles[0] != "6" {
+		t.Errorf("expected the alert to mention the role, got %v", msg.AllowedMentions)
+	}
+}
+
+func TestDowntimeAlertsConfigure(t *testing.T) {
+	_, restore := withTestConfig(t, "")
+	defer restore()
+
+	da := &DowntimeAlerts{Guilds: make(map[string]*DowntimeAlert)}
+	a, err := da.Configure("1", "channel", "", "5")
+	if err != nil || a.ChannelID != "5" {
+		t.Fatalf("expected the channel to be set, got %v: %v", a, err)
+	}
+	a, err = da.Configure("1", "role", "<@&6>", "")
+	if err != nil || a.RoleID != "6" {
+		t.Fatalf("expected the role to be set, got %v: %v", a, err)
+	}
+	a, err = da.Configure("1", "threshold", "4 5", "")
+	if err != nil || a.failures() != 4 || a.recoveries() != 5 {
+		t.Fatalf("expected the thresholds to be set, got %v: %v", a, err)
+	}
+
+	for _, args := range [][2]string{{"threshold", ""}, {"unknown", ""}} {
+		if _, err := da.Configure("1", args[0], args[1], ""); err != errAlertsUsage {
+			t.Errorf("expected the usage for %v, got %v", args, err)
+		}
+	}
+	for _, args := range [][2]string{{"role", "6"}, {"threshold", "0"}} {
+		if _, err := da.Configure("1", args[0], args[1], ""); err == nil || err == errAlertsUsage {
+			t.Errorf("expected an error for %v, got %v", args, err)
+		}
+	}
+
+	loaded, err := LoadDowntimeAlerts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := loaded.Guilds["1"]; a == nil || a.ChannelID != "5" || a.RoleID != "6" || a.Failures != 4 {
+		t.Fatalf("expected the settings to be saved, got %v", a)
+	}
+}
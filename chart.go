@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	chartWidth  = 800
+	chartHeight = 400
+
+	chartMarginLeft   = 50
+	chartMarginRight  = 20
+	chartMarginTop    = 30
+	chartMarginBottom = 30
+
+	chartYTicks = 5
+	chartXTicks = 6
+)
+
+var (
+	chartBackground = color.RGBA{0x2f, 0x31, 0x36, 0xff}
+	chartGrid       = color.RGBA{0x4f, 0x54, 0x5c, 0xff}
+	chartText       = color.RGBA{0xdc, 0xdd, 0xde, 0xff}
+	chartLine       = color.RGBA{0x58, 0x65, 0xf2, 0xff}
+)
+
+// chartPoint is a single value of a time series
+type chartPoint struct {
+	Time  time.Time
+	Value float64
+}
+
+// renderLineChart draws the points between from and to as PNG image.
+// Points that are further apart than two steps are not connected.
+func renderLineChart(title string, points []chartPoint, from, to time.Time, step time.Duration) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, chartWidth, chartHeight))
+	draw.Draw(img, img.Bounds(), &image.Uniform{chartBackground}, image.Point{}, draw.Src)
+
+	plot := image.Rect(chartMarginLeft, chartMarginTop, chartWidth-chartMarginRight, chartHeight-chartMarginBottom)
+
+	maxValue := 1.0
+	for _, p := range points {
+		maxValue = math.Max(maxValue, p.Value)
+	}
+	// round up to a multiple of the number of ticks in order to get whole numbers as labels
+	maxValue = math.Ceil(maxValue/chartYTicks) * chartYTicks
+
+	x := func(t time.Time) int {
+		return plot.Min.X + int(float64(plot.Dx())*float64(t.Sub(from))/float64(to.Sub(from)))
+	}
+	y := func(value float64) int {
+		return plot.Max.Y - int(float64(plot.Dy())*value/maxValue)
+	}
+
+	// horizontal grid lines with the number of players
+	for tick := 0; tick <= chartYTicks; tick++ {
+		value := maxValue * float64(tick) / chartYTicks
+		drawLine(img, plot.Min.X, y(value), plot.Max.X, y(value), chartGrid)
+
+		label := fmt.Sprintf("%.0f", value)
+		drawText(img, plot.Min.X-8-textWidth(label), y(value)+4, label, chartText)
+	}
+
+	// vertical grid lines with the time
+	layout := "15:04"
+	if to.Sub(from) > 24*time.Hour {
+		layout = "Jan 02"
+	}
+	for tick := 0; tick <= chartXTicks; tick++ {
+		t := from.Add(time.Duration(int64(to.Sub(from)) * int64(tick) / chartXTicks))
+		drawLine(img, x(t), plot.Min.Y, x(t), plot.Max.Y, chartGrid)
+
+		label := t.Format(layout)
+		drawText(img, x(t)-textWidth(label)/2, plot.Max.Y+18, label, chartText)
+	}
+
+	drawText(img, plot.Min.X, chartMarginTop-12, title, chartText)
+
+	for idx := 1; idx < len(points); idx++ {
+		prev, p := points[idx-1], points[idx]
+		if p.Time.Sub(prev.Time) > 2*step {
+			continue
+		}
+		x0, y0, x1, y1 := x(prev.Time), y(prev.Value), x(p.Time), y(p.Value)
+		drawLine(img, x0, y0, x1, y1, chartLine)
+		drawLine(img, x0, y0-1, x1, y1-1, chartLine)
+	}
+	if len(points) == 1 {
+		p := points[0]
+		drawLine(img, x(p.Time)-1, y(p.Value), x(p.Time)+1, y(p.Value), chartLine)
+	}
+
+	buf := bytes.Buffer{}
+	err := png.Encode(&buf, img)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// drawLine draws a line from (x0, y0) to (x1, y1) with Bresenham's algorithm
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.Color) {
+	dx, dy := absInt(x1-x0), -absInt(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+
+	e := dx + dy
+	for {
+		img.Set(x0, y0, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+// drawText draws the text with its baseline starting at (x, y)
+func drawText(img *image.RGBA, x, y int, text string, c color.Color) {
+	d := font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(c),
+		Face: basicfont.Face7x13,
+		Dot:  fixed.P(x, y),
+	}
+	d.DrawString(text)
+}
+
+// textWidth returns the width of the text in pixels
+func textWidth(text string) int {
+	return font.MeasureString(basicfont.Face7x13, text).Round()
+}
+
+func absInt(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// the delay between reconnects is doubled after every failed attempt up to the maximum
+	chatBridgeMinReconnectDelay = 5 * time.Second
+	chatBridgeMaxReconnectDelay = 5 * time.Minute
+
+	// chat lines that are not sent to discord yet, further lines are dropped
+	chatBridgeQueueSize = 100
+
+	// the game cuts longer chat messages off
+	maxGameChatLength = 120
+)
+
+var (
+	// [chat]: 0:-2:nameless tee: hello, messages of the server itself look like [chat]: *** hello
+	econChatRegex = regexp.MustCompile(`\[chat\]: (\d+):(-?\d+):(.*?): (.*)$`)
+	// custom emojis look like <:name:id> or <a:name:id>
+	customEmojiRegex = regexp.MustCompile(`<a?(:\w+:)\d+>`)
+)
+
+// NewChatBridges creates a bridge for every server that has a chat channel
+func NewChatBridges(ec *EconConfig) *ChatBridges {
+	cb := &ChatBridges{channels: make(map[string]*ChatBridge)}
+	for _, srv := range ec.Servers {
+		if srv.ChatChannelID != "" {
+			cb.channels[srv.ChatChannelID] = &ChatBridge{
+				server: srv,
+				queue:  make(chan string, chatBridgeQueueSize),
+			}
+		}
+	}
+	return cb
+}
+
+// ChatBridges maps discord channel IDs to the bridge of their server
+type ChatBridges struct {
+	channels map[string]*ChatBridge
+}
+
+// Run connects all bridges and keeps them connected until stop is closed
+func (cb *ChatBridges) Run(s *discordgo.Session, stop <-chan struct{}) {
+	for channelID, b := range cb.channels {
+		go b.Run(s, channelID, stop)
+	}
+}
+
+// Lookup returns the bridge of the discord channel
+func (cb *ChatBridges) Lookup(channelID string) (*ChatBridge, bool) {
+	b, ok := cb.channels[channelID]
+	return b, ok
+}
+
+// ChatBridge relays the chat of a server to a discord channel and the other way around
+type ChatBridge struct {
+	sync.Mutex
+	server *EconServer
+	// nil while disconnected
+	client *EconClient
+	queue  chan string
+}
+
+// Run keeps the external console connection open and relays the chat until stop is closed
+func (b *ChatBridge) Run(s *discordgo.Session, channelID string, stop <-chan struct{}) {
+	go b.post(s, channelID, stop)
+
+	delay := chatBridgeMinReconnectDelay
+	for {
+		c, err := DialEcon(b.server.Address, b.server.Password)
+		if err != nil {
+			log.Printf("chat bridge of %s failed to connect, retrying in %s: %v\n", b.server.Server, delay, err)
+		} else {
+			log.Printf("chat bridge of %s connected\n", b.server.Server)
+			delay = chatBridgeMinReconnectDelay
+
+			err = b.relay(c, stop)
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			log.Printf("chat bridge of %s lost the connection, reconnecting in %s: %v\n", b.server.Server, delay, err)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > chatBridgeMaxReconnectDelay {
+			delay = chatBridgeMaxReconnectDelay
+		}
+	}
+}
+
+// relay reads the console output until the connection breaks or stop is closed
+func (b *ChatBridge) relay(c *EconClient, stop <-chan struct{}) error {
+	b.Lock()
+	b.client = c
+	b.Unlock()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		// unblocks ReadLine
+		select {
+		case <-stop:
+		case <-done:
+		}
+		c.Close()
+	}()
+
+	defer func() {
+		b.Lock()
+		b.client = nil
+		b.Unlock()
+	}()
+
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return err
+		}
+
+		m := econChatRegex.FindStringSubmatch(line)
+		if len(m) != 5 {
+			continue
+		}
+
+		select {
+		case b.queue <- fmt.Sprintf("**%s**: %s", Escape(m[3]), Escape(m[4])):
+		default:
+			log.Printf("chat bridge of %s dropped a chat message, discord is too slow\n", b.server.Server)
+		}
+	}
+}
+
+// post sends the queued chat lines to the discord channel, lines that were queued
+// while the previous message was sent are combined into a single message.
+func (b *ChatBridge) post(s *discordgo.Session, channelID string, stop <-chan struct{}) {
+	for {
+		var line string
+		select {
+		case <-stop:
+			return
+		case line = <-b.queue:
+		}
+
+		lines := []string{line}
+	collect:
+		for {
+			select {
+			case line = <-b.queue:
+				lines = append(lines, line)
+			default:
+				break collect
+			}
+		}
+
+		w := NewOutputWriter()
+		for _, line := range lines {
+			w.WriteLine(line)
+		}
+		for _, msg := range w.Messages() {
+			_, err := s.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+				Content: msg,
+				// players must not be able to ping anyone
+				AllowedMentions: &discordgo.MessageAllowedMentions{},
+			})
+			if err != nil {
+				log.Printf("chat bridge of %s failed to post the chat: %v\n", b.server.Server, err)
+				break
+			}
+		}
+	}
+}
+
+// Say sends the chat message to the server
+func (b *ChatBridge) Say(name, text string) error {
+	b.Lock()
+	c := b.client
+	b.Unlock()
+
+	if c == nil {
+		return fmt.Errorf("the chat bridge of %s is not connected", b.server.Server)
+	}
+	return c.Send("say " + econQuote(sanitizeGameChat(fmt.Sprintf("[D] %s: %s", name, text))))
+}
+
+// sanitizeGameChat replaces control characters and line breaks with spaces, collapses whitespace
+// and cuts the text off at the length the game displays.
+func sanitizeGameChat(text string) string {
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, text)
+	text = strings.Join(strings.Fields(text), " ")
+
+	runes := []rune(text)
+	if len(runes) > maxGameChatLength {
+		text = string(runes[:maxGameChatLength-3]) + "..."
+	}
+	return text
+}
+
+// ChatBridgeMessageCreateHandler relays the messages of bridged channels into the game
+func ChatBridgeMessageCreateHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if m.Author == nil || m.Author.Bot || m.WebhookID != "" {
+		return
+	}
+
+	b, ok := config.ChatBridges.Lookup(m.ChannelID)
+	if !ok {
+		return
+	}
+
+	// commands are not relayed
+	if _, ok := stripCommandPrefix(s, m, m.Content); ok {
+		return
+	}
+
+	text := customEmojiRegex.ReplaceAllString(m.ContentWithMentionsReplaced(), "$1")
+	if len(m.Attachments) > 0 {
+		text += " [attachment]"
+	}
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+
+	name := m.Author.Username
+	if m.Member != nil && m.Member.Nick != "" {
+		name = m.Member.Nick
+	}
+
+	err := b.Say(name, text)
+	if err != nil {
+		log.Printf("failed to relay a message into the game: %v\n", err)
+		s.MessageReactionAdd(m.ChannelID, m.ID, "❌")
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+// registerCommands registers all commands of the bot in the order they are listed by !help
+func registerCommands(r *CommandRouter) error {
+	commands := []*Command{
+		{
+			Name:        "help",
+			Aliases:     []string{"h"},
+			Arguments:   "[command]",
+			Description: "Show the available commands.",
+			Handler:     HelpHandler,
+		},
+		{
+			Name:        "online",
+			Aliases:     []string{"o"},
+			Arguments:   "[-full] [gametype|group]",
+			Description: "List all registered servers that have players playing.",
+			Details:     "Only servers of the group or whose gametype contains the given text are listed, without an argument the guild's default filter is used. -full sorts the players by their score and lists the spectators separately.",
+			Handler:     OnlineHandler,
+		},
+		{
+			Name:        "servers",
+			Aliases:     []string{"s"},
+			Arguments:   "[group]",
+			Description: "Show all servers that are currently registered.",
+			Details:     "Hidden servers are only shown to moderators and admins.",
+			Handler:     ServersHandler,
+		},
+		{
+			Name:        "find",
+			Aliases:     []string{"f"},
+			Arguments:   "<name>",
+			Description: "Find the servers a player or clan is playing on.",
+			Details:     "Names and clans are matched case-insensitively, similar names are listed after the exact matches.",
+			Handler:     FindHandler,
+		},
+		{
+			Name:        "uptime",
+			Arguments:   "[address|alias]",
+			Description: "Show how reliably the servers responded within the last hour, day and week.",
+			Handler:     UptimeHandler,
+		},
+		{
+			Name:        "stats",
+			Arguments:   "[address|alias] [24h|7d|30d]",
+			Description: "Show a chart of the number of players within the last day, week or month.",
+			Handler:     StatsHandler,
+		},
+		{
+			Name:        "playtime",
+			Arguments:   "<name>",
+			Description: "Show how long a player played on the servers.",
+			Handler:     PlaytimeHandler,
+		},
+		{
+			Name:        "top",
+			Arguments:   "[gametype] [week|month|all]",
+			Description: "List the players with the most playtime.",
+			Handler:     TopHandler,
+		},
+		{
+			Name:        "watch",
+			Arguments:   "[-exact|-regex] <name>",
+			Description: "Receive a direct message when a player joins one of the servers.",
+			Details:     "Names are matched case-insensitively by default, -exact matches case-sensitively and -regex matches a regular expression.",
+			Handler:     WatchHandler,
+		},
+		{
+			Name:        "unwatch",
+			Arguments:   "<name>",
+			Description: "Stop watching a player.",
+			Handler:     UnwatchHandler,
+		},
+		{
+			Name:        "watchlist",
+			Description: "Show the players you are watching.",
+			Handler:     WatchlistHandler,
+		},
+		{
+			Name:        "add",
+			Arguments:   "<ip:port|hostname:port> [alias=<alias>] [group=<group,...>] [description=\"<text>\"] [hidden]",
+			Description: "Register a new server.",
+			Details:     "Hostnames are resolved again periodically and saved as hostname. The alias can be used instead of the address, hidden servers are not listed.",
+			Permission:  PermissionModerator,
+			Handler:     AddHandler,
+		},
+		{
+			Name:        "delete",
+			Arguments:   "<address|alias>",
+			Description: "Remove a registered server.",
+			Permission:  PermissionModerator,
+			Handler:     DeleteHandler,
+		},
+		{
+			Name:        "save",
+			Description: "Save the registered servers to the server file.",
+			Permission:  PermissionAdmin,
+			Handler:     SaveHandler,
+		},
+		{
+			Name:        "clear",
+			Aliases:     []string{"c", "clean"},
+			Arguments:   "[retries]",
+			Description: "Remove all registered servers that do not respond.",
+			Permission:  PermissionAdmin,
+			Handler:     ClearHandler,
+		},
+		{
+			Name:        "discover",
+			Arguments:   "[-gametype <gametype>] [-name <regex>] [-range <cidr>] | accept [numbers] | cancel",
+			Description: "Find servers at the master servers and register them.",
+			Details:     "The discovered servers are only listed, accept registers all of them or the servers with the given numbers.",
+			Permission:  PermissionAdmin,
+			Handler:     DiscoverHandler,
+		},
+		{
+			Name:        "rcon",
+			Arguments:   "<address|alias> <command>",
+			Description: "Execute a command in the external console of a server.",
+			Details:     "The output of the command is relayed to the channel. The external console credentials are configured in the ECON_FILE.",
+			Permission:  PermissionAdmin,
+			Handler:     RconHandler,
+		},
+		{
+			Name:        "kick",
+			Arguments:   "<address|alias> <player>",
+			Description: "Kick a player from a server.",
+			Permission:  PermissionAdmin,
+			Handler:     KickHandler,
+		},
+		{
+			Name:        "map",
+			Arguments:   "<address|alias> <map>",
+			Description: "Change the map of a server.",
+			Permission:  PermissionAdmin,
+			Handler:     MapHandler,
+		},
+		{
+			Name:        "statusboard",
+			Arguments:   "[#channel|off]",
+			Description: "Show the online servers in a message that is updated automatically.",
+			Details:     "The message is created in the current channel if no channel is given.",
+			Permission:  PermissionAdmin,
+			Handler:     StatusBoardHandler,
+		},
+		{
+			Name:        "notify",
+			Arguments:   "[channel [#channel|off] | add <address> | remove <address>]",
+			Description: "Configure player join/leave notifications.",
+			Details:     "Notifications are only posted for servers that were added.",
+			Permission:  PermissionAdmin,
+			Handler:     NotifyHandler,
+		},
+		{
+			Name:        "prefix",
+			Arguments:   "[prefix]",
+			Description: "Show or change the command prefix of this guild.",
+			Details:     "Changing the prefix requires the admin permission. Mentioning the bot instead of using the prefix always works.",
+			Handler:     PrefixHandler,
+		},
+		{
+			Name:        "filter",
+			Arguments:   "[gametype|off|reset]",
+			Description: "Show or change the default gametype filter of this guild.",
+			Details:     "off shows all gametypes by default, reset uses the bot's default filter again.",
+			Permission:  PermissionAdmin,
+			Handler:     FilterHandler,
+		},
+		{
+			Name:        "output",
+			Arguments:   "[embed|text]",
+			Description: "Show or change whether !online and !servers use embeds or plain text.",
+			Permission:  PermissionAdmin,
+			Handler:     OutputHandler,
+		},
+		{
+			Name:        "alerts",
+			Arguments:   "[channel [#channel|off] | role <@role|off> | threshold <failures> [recoveries]]",
+			Description: "Configure alerts for servers that stop responding.",
+			Details:     "A server is down after the given number of failed polls in a row and up again after the given number of successful polls in a row, which prevents alerts for servers that are flapping.",
+			Permission:  PermissionAdmin,
+			Handler:     AlertsHandler,
+		},
+		{
+			Name:        "logchannel",
+			Arguments:   "[#channel|off]",
+			Description: "Post changes of the server list into a channel.",
+			Details:     "The server file is reloaded when it is changed on disk or when the bot receives SIGHUP. The changes are posted to the current channel if no channel is given.",
+			Permission:  PermissionAdmin,
+			Handler:     LogChannelHandler,
+		},
+		{
+			Name:        "grant",
+			Arguments:   "<@user|@role> <admin|moderator>",
+			Description: "Grant permissions to a user or role.",
+			Details:     "Moderators are allowed to add and delete servers, admins are allowed to execute every command.",
+			Permission:  PermissionAdmin,
+			Handler:     GrantHandler,
+		},
+		{
+			Name:        "revoke",
+			Arguments:   "<@user|@role>",
+			Description: "Revoke the permissions of a user or role.",
+			Permission:  PermissionAdmin,
+			Handler:     RevokeHandler,
+		},
+		{
+			Name:        "permissions",
+			Description: "List all granted permissions.",
+			Permission:  PermissionAdmin,
+			Handler:     PermissionsHandler,
+		},
+	}
+
+	for _, c := range commands {
+		err := r.Register(c)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,22 +2,134 @@ package main
 
 import (
 	"errors"
+	"fmt"
+	"log"
 	"net"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 )
 
+var (
+	hostnameRegex = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?\.)*[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?$`)
+
+	// aliases and groups must not look like addresses
+	labelRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,32}$`)
+)
+
+// ServerEntry is a registered server that is either given as ip:port or as hostname:port.
+// Hostnames are resolved to one or more addresses.
+type ServerEntry struct {
+	Hostname string
+	Port     int
+	Addrs    []*net.UDPAddr
+
+	// Alias can be used instead of the address
+	Alias string
+	// Groups are lowercase tags like regions or gametypes that commands can filter by
+	Groups      []string
+	Description string
+	// Hidden servers are polled, but not listed
+	Hidden bool
+}
+
+// String returns the address the way it was registered
+func (e *ServerEntry) String() string {
+	if e.Hostname != "" {
+		return net.JoinHostPort(e.Hostname, strconv.Itoa(e.Port))
+	}
+	if len(e.Addrs) == 0 {
+		return ""
+	}
+	return e.Addrs[0].String()
+}
+
+// HasAddr returns true if the entry resolved to the given ip:port
+func (e *ServerEntry) HasAddr(address string) bool {
+	for _, addr := range e.Addrs {
+		if addr.String() == address {
+			return true
+		}
+	}
+	return false
+}
+
+// InGroup returns true if the entry is tagged with the group
+func (e *ServerEntry) InGroup(group string) bool {
+	for _, g := range e.Groups {
+		if strings.EqualFold(g, group) {
+			return true
+		}
+	}
+	return false
+}
+
+// Label returns the alias of the entry or its registered address
+func (e *ServerEntry) Label() string {
+	if e.Alias != "" {
+		return e.Alias
+	}
+	return e.String()
+}
+
+// sameMetadata returns true if both entries have the same alias, groups, description and hidden flag
+func (e *ServerEntry) sameMetadata(other *ServerEntry) bool {
+	if e.Alias != other.Alias || e.Description != other.Description || e.Hidden != other.Hidden || len(e.Groups) != len(other.Groups) {
+		return false
+	}
+	for idx := range e.Groups {
+		if e.Groups[idx] != other.Groups[idx] {
+			return false
+		}
+	}
+	return true
+}
+
+// matches returns true if the other entry describes the same registered server
+func (e *ServerEntry) matches(other *ServerEntry) bool {
+	if e.Hostname != "" || other.Hostname != "" {
+		return strings.EqualFold(e.Hostname, other.Hostname) && e.Port == other.Port
+	}
+	return e.Addrs[0].IP.Equal(other.Addrs[0].IP) && e.Port == other.Port
+}
+
+// resolve looks up the addresses of a hostname entry, literal IPs are not resolved again
+func (e *ServerEntry) resolve() error {
+	if e.Hostname == "" {
+		return nil
+	}
+
+	ips, err := net.LookupIP(e.Hostname)
+	if err != nil {
+		return err
+	}
+	if len(ips) == 0 {
+		return fmt.Errorf("no addresses found for %s", e.Hostname)
+	}
+
+	addrs := make([]*net.UDPAddr, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, &net.UDPAddr{IP: ip, Port: e.Port})
+	}
+	sort.Sort(byAddress(addrs))
+	e.Addrs = addrs
+	return nil
+}
+
 // NewConcurrentServerList creates a new empty list with capacity empty slots
 func NewConcurrentServerList(capacity int) *ConcurrentServerList {
-	return &ConcurrentServerList{list: make([]*net.UDPAddr, 0, capacity)}
+	return &ConcurrentServerList{list: make([]*ServerEntry, 0, capacity)}
 }
 
 // ConcurrentServerList allows for concurrent access
 type ConcurrentServerList struct {
 	sync.Mutex
-	list []*net.UDPAddr
+	list []*ServerEntry
+	// changes counts the modifications of the list, saved is the count that was written to the server file
+	changes int
+	saved   int
 }
 
 // Len of the list
@@ -28,55 +140,169 @@ func (c *ConcurrentServerList) Len() int {
 	return len(c.list)
 }
 
-// Add adds only unique new servers to the list
-func (c *ConcurrentServerList) Add(address string) error {
-	matches := extractIPRegex.FindStringSubmatch(strings.TrimSpace(address))
-
-	if len(matches) != 3 {
-		return errors.New("invalid address format")
+// Add adds only unique new servers to the list, hostnames must be resolvable.
+// The address may be followed by the same metadata as in the server file, e.g. alias=ger1 group=eu
+func (c *ConcurrentServerList) Add(line string) error {
+	entry, err := parseServerLine(line)
+	if err != nil {
+		return err
 	}
 
-	IP := net.ParseIP(matches[1])
-	if IP == nil {
-		return errors.New("invalid IP format")
+	err = entry.resolve()
+	if err != nil {
+		return fmt.Errorf("could not resolve %s", entry.Hostname)
 	}
 
-	port, err := strconv.Atoi(matches[2])
+	err = c.add(entry)
 	if err != nil {
-		return errors.New("invalid port format")
-	}
-	if port <= 1024 {
-		return errors.New("port should be bigger than 1024")
+		return err
 	}
 
+	c.Lock()
+	c.changes++
+	c.Unlock()
+	return nil
+}
+
+// add appends the entry if it is not part of the list yet
+func (c *ConcurrentServerList) add(entry *ServerEntry) error {
 	c.Lock()
 	defer c.Unlock()
 
 	for _, s := range c.list {
-		if s.IP.Equal(IP) && s.Port == port {
+		if s.matches(entry) {
 			return errors.New("server address already exists")
 		}
+		if entry.Alias != "" && strings.EqualFold(s.Alias, entry.Alias) {
+			return fmt.Errorf("alias %s is already used by %s", entry.Alias, s.String())
+		}
 	}
 
-	c.list = append(c.list, &net.UDPAddr{IP: IP, Port: port})
+	c.list = append(c.list, entry)
 	return nil
 }
 
-// List returns a copy of the list
+// Contains returns true if the address is part of the list,
+// either as registered address or as one of the resolved addresses.
+func (c *ConcurrentServerList) Contains(address string) bool {
+	_, ok := c.Lookup(address)
+	return ok
+}
+
+// Lookup returns a copy of the entry that was registered with the address or alias
+// or that resolved to the address.
+func (c *ConcurrentServerList) Lookup(address string) (ServerEntry, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	idx := c.find(address)
+	if idx < 0 {
+		return ServerEntry{}, false
+	}
+	return *c.list[idx], true
+}
+
+// find returns the position of an address or alias.
+// Must be called with the lock held.
+func (c *ConcurrentServerList) find(address string) int {
+	entry, err := parseAddress(address)
+	if err == nil {
+		return c.index(entry)
+	}
+
+	alias := strings.TrimSpace(address)
+	for idx, s := range c.list {
+		if s.Alias != "" && strings.EqualFold(s.Alias, alias) {
+			return idx
+		}
+	}
+	return -1
+}
+
+// index returns the position of the entry, ip:port addresses also match resolved addresses.
+// Must be called with the lock held.
+func (c *ConcurrentServerList) index(entry *ServerEntry) int {
+	for idx, s := range c.list {
+		if s.matches(entry) {
+			return idx
+		}
+	}
+	if entry.Hostname != "" {
+		return -1
+	}
+	for idx, s := range c.list {
+		if s.HasAddr(entry.String()) {
+			return idx
+		}
+	}
+	return -1
+}
+
+// DisplayAddress returns the registered address of a resolved ip:port,
+// the ip:port itself is returned if it is not part of the list.
+func (c *ConcurrentServerList) DisplayAddress(address string) string {
+	entry, ok := c.Resolved(address)
+	if !ok || entry.Hostname == "" {
+		return address
+	}
+	return entry.String()
+}
+
+// Resolved returns a copy of the entry that resolved to the ip:port
+func (c *ConcurrentServerList) Resolved(address string) (ServerEntry, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	for _, s := range c.list {
+		if s.HasAddr(address) {
+			return *s, true
+		}
+	}
+	return ServerEntry{}, false
+}
+
+// HasGroup returns true if at least one server is tagged with the group
+func (c *ConcurrentServerList) HasGroup(group string) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	for _, s := range c.list {
+		if s.InGroup(group) {
+			return true
+		}
+	}
+	return false
+}
+
+// Entries returns a copy of the registered servers sorted by their registered address
+func (c *ConcurrentServerList) Entries() []ServerEntry {
+	c.Lock()
+	entries := make([]ServerEntry, 0, len(c.list))
+	for _, s := range c.list {
+		entries = append(entries, *s)
+	}
+	c.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].String() < entries[j].String()
+	})
+	return entries
+}
+
+// List returns a copy of all resolved addresses
 func (c *ConcurrentServerList) List() (list []*net.UDPAddr) {
 
 	c.Lock()
 	list = make([]*net.UDPAddr, 0, len(c.list))
 
 	for _, s := range c.list {
-		s := s
-		list = append(list, s)
+		list = append(list, s.Addrs...)
 	}
 	c.Unlock()
 	return
 }
 
-// SortedList returns a copy of the list
+// SortedList returns a sorted copy of all resolved addresses
 func (c *ConcurrentServerList) SortedList() (list []*net.UDPAddr) {
 
 	list = c.List()
@@ -85,42 +311,182 @@ func (c *ConcurrentServerList) SortedList() (list []*net.UDPAddr) {
 	return
 }
 
-// Detete an entry from the list
-func (c *ConcurrentServerList) Delete(address string) error {
-	matches := extractIPRegex.FindStringSubmatch(strings.TrimSpace(address))
+// Resolve looks up the addresses of all hostnames again.
+// Entries keep their previous addresses if the lookup fails.
+func (c *ConcurrentServerList) Resolve() {
+	c.Lock()
+	hostnames := make([]ServerEntry, 0, len(c.list))
+	for _, s := range c.list {
+		if s.Hostname != "" {
+			hostnames = append(hostnames, ServerEntry{Hostname: s.Hostname, Port: s.Port})
+		}
+	}
+	c.Unlock()
 
-	if len(matches) != 3 {
-		return errors.New("invalid address format")
+	// lookups may take a while, the list must not be locked in the meantime
+	for idx := range hostnames {
+		entry := &hostnames[idx]
+		err := entry.resolve()
+		if err != nil {
+			log.Printf("failed to resolve %s: %v\n", entry.String(), err)
+			continue
+		}
+
+		c.Lock()
+		for _, s := range c.list {
+			if s.matches(entry) {
+				s.Addrs = entry.Addrs
+			}
+		}
+		c.Unlock()
 	}
+}
+
+// ServerListDiff contains the registered addresses that changed when a list was replaced
+type ServerListDiff struct {
+	Added   []string
+	Removed []string
+	// Changed servers have different metadata
+	Changed []string
+}
+
+// Empty returns true if nothing changed
+func (d *ServerListDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
 
-	IP := net.ParseIP(matches[1])
-	if IP == nil {
-		return errors.New("invalid IP format")
+// String lists the changes line by line
+func (d *ServerListDiff) String() string {
+	sb := strings.Builder{}
+	for _, change := range []struct {
+		prefix    string
+		addresses []string
+	}{{"added", d.Added}, {"removed", d.Removed}, {"changed", d.Changed}} {
+		for _, address := range change.addresses {
+			sb.WriteString(fmt.Sprintf("%s: %s\n", change.prefix, address))
+		}
 	}
+	return sb.String()
+}
 
-	port, err := strconv.Atoi(matches[2])
-	if err != nil {
-		return errors.New("invalid port format")
+// Replace swaps the entries of the list with the entries of the other list at once.
+// The other list must not be used afterwards.
+func (c *ConcurrentServerList) Replace(other *ConcurrentServerList) ServerListDiff {
+	other.Lock()
+	entries := other.list
+	other.Unlock()
+
+	c.Lock()
+	defer c.Unlock()
+
+	previous := make(map[string]*ServerEntry, len(c.list))
+	for _, s := range c.list {
+		previous[s.String()] = s
+	}
+
+	diff := ServerListDiff{}
+	for _, s := range entries {
+		address := s.String()
+		old, ok := previous[address]
+		if ok && len(s.Addrs) == 0 {
+			// hostname that could not be resolved right now
+			s.Addrs = old.Addrs
+		}
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, address)
+		case !old.sameMetadata(s):
+			diff.Changed = append(diff.Changed, address)
+		}
+		delete(previous, address)
 	}
-	if port <= 1024 {
-		return errors.New("port should be bigger than 1024")
+	for address := range previous {
+		diff.Removed = append(diff.Removed, address)
 	}
 
-	position := -1
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
 
+	c.list = entries
+	// the entries were read from the server file
+	c.changes++
+	c.saved = c.changes
+	return diff
+}
+
+// Changes returns the number of modifications, it is passed to Saved after the list was saved.
+func (c *ConcurrentServerList) Changes() int {
 	c.Lock()
 	defer c.Unlock()
-	for idx, s := range c.list {
-		if s.IP.Equal(IP) && s.Port == port {
-			position = idx
-		}
+
+	return c.changes
+}
+
+// Saved marks the modifications up to changes as saved to the server file
+func (c *ConcurrentServerList) Saved(changes int) {
+	c.Lock()
+	defer c.Unlock()
+
+	if changes > c.saved {
+		c.saved = changes
 	}
+}
 
+// Unsaved returns true if the list was modified since it was loaded from or saved to the server file
+func (c *ConcurrentServerList) Unsaved() bool {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.changes != c.saved
+}
+
+// Detete an entry from the list, the entry is found by its registered
+// address, its alias or by one of its resolved addresses.
+func (c *ConcurrentServerList) Delete(address string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	position := c.find(address)
 	if position < 0 {
-		return errors.New("IP not found")
+		return errors.New("server not found")
 	}
 
 	c.list = append(c.list[:position], c.list[position+1:]...)
+	c.changes++
 
 	return nil
 }
+
+// parseAddress validates an ip:port or hostname:port address.
+// Hostnames are not resolved yet.
+func parseAddress(address string) (*ServerEntry, error) {
+	address = strings.TrimSpace(address)
+
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		// IPv6 addresses without brackets
+		matches := extractIPRegex.FindStringSubmatch(address)
+		if len(matches) != 3 {
+			return nil, errors.New("invalid address format")
+		}
+		host, portStr = matches[1], matches[2]
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, errors.New("invalid port format")
+	}
+	if port <= 1024 || port > 65535 {
+		return nil, errors.New("port should be bigger than 1024")
+	}
+
+	if IP := net.ParseIP(host); IP != nil {
+		return &ServerEntry{Port: port, Addrs: []*net.UDPAddr{{IP: IP, Port: port}}}, nil
+	}
+
+	if len(host) > 253 || !hostnameRegex.MatchString(host) {
+		return nil, errors.New("invalid IP or hostname format")
+	}
+	return &ServerEntry{Hostname: strings.ToLower(host), Port: port}, nil
+}
@@ -8,12 +8,29 @@ import (
 
 // Config contains the current bot configuration and structs
 type Config struct {
-	Admin                 string
 	FilePath              string
+	AutoSave              bool
+	ServerFileBackups     int
+	DataDir               string
 	DefaultGameTypeFilter string
 	DiscordSession        *discordgo.Session
 	ResponseTimeout       time.Duration
-	ServerList            *ConcurrentServerList
+	PollInterval          time.Duration
+	ResolveInterval       time.Duration
+	WatchInterval         time.Duration
+	ServerInfos           *ServerInfoCache
+	StatusBoards          *StatusBoards
+	PlayerNotifiers       *PlayerNotifiers
+	Watchlist             *Watchlist
+	Uptime                *UptimeTracker
+	PlayerCounts          *PlayerCountHistory
+	Playtime              *PlaytimeTracker
+	Econ                  *EconConfig
+	ChatBridges           *ChatBridges
+	DowntimeAlerts        *DowntimeAlerts
+	Commands              *CommandRouter
+	Guilds                *GuildConfigs
+	Discoverer            *Discoverer
 }
 
 // NewBotConfig creates a new discord configuration to work as a discord bot.
@@ -23,8 +40,12 @@ func NewBotConfig(discordToken string) (*Config, error) {
 		return nil, err
 	}
 
+	// reading the commands requires the privileged message content intent
+	discordSession.Identify.Intents = discordgo.IntentsAllWithoutPrivileged | discordgo.IntentsMessageContent
+
 	return &Config{
-		DiscordSession: discordSession}, nil
+		DiscordSession: discordSession,
+		ServerInfos:    NewServerInfoCache()}, nil
 }
 
 // Open starts the connection to the discord servers
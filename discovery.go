@@ -0,0 +1,378 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jxsl13/twapi/browser"
+)
+
+const (
+	// discovered servers that were not accepted are dropped after discoveryExpiration
+	discoveryExpiration = 10 * time.Minute
+
+	// maxDiscoveryPreview limits how many discovered servers are listed, all of them can be accepted
+	maxDiscoveryPreview = 50
+
+	// discoveryWorkers limits how many discovered servers are queried at the same time
+	discoveryWorkers = 64
+)
+
+var (
+	// pendingDiscoveries maps guild IDs to the servers that were discovered, but not accepted yet
+	pendingDiscoveries = struct {
+		sync.Mutex
+		results map[string]*discoveryResult
+	}{results: make(map[string]*discoveryResult)}
+)
+
+// discoveryResult is a preview of discovered servers that can be accepted
+type discoveryResult struct {
+	infos     []browser.ServerInfo
+	createdAt time.Time
+}
+
+func (r *discoveryResult) expired() bool {
+	return time.Since(r.createdAt) > discoveryExpiration
+}
+
+// DiscoveryFilter selects the discovered servers, empty fields match every server
+type DiscoveryFilter struct {
+	// GameType is matched case-insensitively against a part of the server's gametype
+	GameType string
+	Name     *regexp.Regexp
+	Network  *net.IPNet
+}
+
+// Matches returns true if the server passes all filters
+func (f *DiscoveryFilter) Matches(info browser.ServerInfo) bool {
+	if f.GameType != "" && !strings.Contains(strings.ToLower(info.GameType), f.GameType) {
+		return false
+	}
+	if f.Name != nil && !f.Name.MatchString(info.Name) {
+		return false
+	}
+	if f.Network != nil {
+		host, _, err := net.SplitHostPort(info.Address)
+		if err != nil {
+			return false
+		}
+		ip := net.ParseIP(host)
+		if ip == nil || !f.Network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseDiscoveryFilter parses -gametype <gametype>, -name <regex> and -range <cidr|ip>
+func parseDiscoveryFilter(args string) (*DiscoveryFilter, error) {
+	filter := &DiscoveryFilter{}
+	fields := strings.Fields(args)
+
+	for idx := 0; idx < len(fields); idx += 2 {
+		if idx+1 >= len(fields) {
+			return nil, fmt.Errorf("missing value for %s", fields[idx])
+		}
+		value := fields[idx+1]
+
+		switch strings.ToLower(fields[idx]) {
+		case "-gametype":
+			filter.GameType = strings.ToLower(value)
+		case "-name":
+			regex, err := regexp.Compile(value)
+			if err != nil {
+				return nil, errors.New("invalid regular expression")
+			}
+			filter.Name = regex
+		case "-range":
+			network, err := parseNetwork(value)
+			if err != nil {
+				return nil, err
+			}
+			filter.Network = network
+		default:
+			return nil, fmt.Errorf("unknown filter: %s", fields[idx])
+		}
+	}
+	return filter, nil
+}
+
+// parseNetwork parses a CIDR range or a single IP
+func parseNetwork(value string) (*net.IPNet, error) {
+	if _, network, err := net.ParseCIDR(value); err == nil {
+		return network, nil
+	}
+
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, errors.New("invalid address range, expected e.g. 1.2.3.0/24")
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// Discoverer queries master servers for the servers that are registered at them.
+// The master servers can be replaced, e.g. by a local fake master server.
+type Discoverer struct {
+	MasterServers []*net.UDPAddr
+	Timeout       time.Duration
+	// Workers limits how many servers are queried at the same time, discoveryWorkers if it is not set
+	Workers int
+}
+
+// ServerList returns the servers of all master servers without duplicates,
+// an error is only returned if no master server responded.
+func (d *Discoverer) ServerList() ([]*net.UDPAddr, error) {
+	type masterResult struct {
+		servers browser.ServerList
+		err     error
+	}
+
+	results := make(chan masterResult, len(d.MasterServers))
+	for _, ms := range d.MasterServers {
+		go func(ms *net.UDPAddr) {
+			servers, err := fetchMasterServerList(ms, d.Timeout)
+			results <- masterResult{servers, err}
+		}(ms)
+	}
+
+	var (
+		set     = make(map[string]bool)
+		servers = make([]*net.UDPAddr, 0, 512)
+		lastErr = errors.New("no master servers configured")
+		replied = false
+	)
+	for range d.MasterServers {
+		result := <-results
+		if result.err != nil {
+			lastErr = result.err
+			continue
+		}
+		replied = true
+
+		for _, srv := range result.servers {
+			if set[srv.String()] {
+				continue
+			}
+			set[srv.String()] = true
+			servers = append(servers, srv)
+		}
+	}
+
+	if !replied {
+		return nil, lastErr
+	}
+	sort.Sort(byAddress(servers))
+	return servers, nil
+}
+
+// Discover fetches the infos of all servers of the master servers that match the filter
+func (d *Discoverer) Discover(filter *DiscoveryFilter) ([]browser.ServerInfo, error) {
+	servers, err := d.ServerList()
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]browser.ServerInfo, 0, len(servers))
+	for _, info := range d.fetchServerInfos(servers) {
+		if info.Name != "" && filter.Matches(info) {
+			matches = append(matches, info)
+		}
+	}
+	sort.Sort(byServerAddress(matches))
+	return matches, nil
+}
+
+// fetchServerInfos fetches the infos of the servers, but only queries d.Workers servers at the same time,
+// as the master servers list thousands of servers.
+func (d *Discoverer) fetchServerInfos(servers []*net.UDPAddr) []browser.ServerInfo {
+	workers := d.Workers
+	if workers <= 0 {
+		workers = discoveryWorkers
+	}
+	if workers > len(servers) {
+		workers = len(servers)
+	}
+
+	cm := browser.NewConcurrentMap(len(servers))
+	latencies := &latencyMap{latencies: make(map[string]time.Duration, len(servers))}
+	addresses := make(chan *net.UDPAddr)
+
+	wg := sync.WaitGroup{}
+	wg.Add(len(servers))
+	for idx := 0; idx < workers; idx++ {
+		go func() {
+			for addr := range addresses {
+				fetchServerInfoFromServerAddress(addr, config.ResponseTimeout, &cm, latencies, &wg)
+			}
+		}()
+	}
+
+	for _, addr := range servers {
+		addresses <- addr
+	}
+	close(addresses)
+	wg.Wait()
+
+	return cm.Values()
+}
+
+// fetchMasterServerList requests the server list of a single master server
+func fetchMasterServerList(ms *net.UDPAddr, timeout time.Duration) (browser.ServerList, error) {
+	conn, err := net.DialUDP("udp", nil, ms)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	resp, err := browser.Fetch("serverlist", conn, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return browser.ParseServerList(resp)
+}
+
+// resolveMasterServers resolves a comma separated list of master server host:port addresses
+func resolveMasterServers(addresses string) ([]*net.UDPAddr, error) {
+	masters := make([]*net.UDPAddr, 0, 4)
+	for _, address := range strings.Split(addresses, ",") {
+		address = strings.TrimSpace(address)
+		if address == "" {
+			continue
+		}
+
+		ms, err := net.ResolveUDPAddr("udp", address)
+		if err != nil {
+			return nil, err
+		}
+		masters = append(masters, ms)
+	}
+	return masters, nil
+}
+
+// DiscoverHandler handles the !discover command
+func DiscoverHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
+	args = strings.TrimSpace(args)
+	subcommand := strings.ToLower(strings.SplitN(args, " ", 2)[0])
+
+	switch subcommand {
+	case "accept":
+		acceptDiscovery(s, m, strings.TrimSpace(args[len(subcommand):]))
+		return
+	case "cancel":
+		pendingDiscoveries.Lock()
+		delete(pendingDiscoveries.results, m.GuildID)
+		pendingDiscoveries.Unlock()
+		respond(s, m, "Discarded the discovered servers.")
+		return
+	}
+
+	filter, err := parseDiscoveryFilter(args)
+	if err != nil {
+		respond(s, m, fmt.Sprintf("%v, %s", err, usage(m, "discover")))
+		return
+	}
+
+	respond(s, m, "Querying the master servers, this may take a few seconds...")
+
+	infos, err := config.Discoverer.Discover(filter)
+	if err != nil {
+		respond(s, m, fmt.Sprintf("failed to query the master servers: %v", err))
+		return
+	}
+
+	// already registered servers are not discovered again
+	list := config.Guilds.ServerList(m.GuildID)
+	discovered := infos[:0]
+	for _, info := range infos {
+		if !list.Contains(info.Address) {
+			discovered = append(discovered, info)
+		}
+	}
+
+	if len(discovered) == 0 {
+		respond(s, m, "No new servers found.")
+		return
+	}
+
+	pendingDiscoveries.Lock()
+	pendingDiscoveries.results[m.GuildID] = &discoveryResult{infos: discovered, createdAt: time.Now()}
+	pendingDiscoveries.Unlock()
+
+	prefix := config.Guilds.Prefix(m.GuildID)
+	w := NewOutputWriter()
+	w.WriteLine(fmt.Sprintf("Found %d new servers:", len(discovered)))
+	for idx, server := range discovered {
+		if idx == maxDiscoveryPreview {
+			w.WriteLine(fmt.Sprintf("... and %d more.", len(discovered)-maxDiscoveryPreview))
+			break
+		}
+
+		playersFormat := fmt.Sprintf("(%d/%d)", server.NumClients, server.MaxClients)
+		w.WriteLine(fmt.Sprintf("%d. **%s** Address: %s Gametype: %s Map: **%s** %s",
+			idx+1, Escape(server.Name), server.Address, Escape(server.GameType), Escape(server.Map), playersFormat))
+	}
+	w.WriteLine(fmt.Sprintf("Use **%s** to add all of them or **%s** to add a selection, the result expires in %s.",
+		Escape(prefix+"discover accept"),
+		Escape(prefix+"discover accept 1 2 3"),
+		discoveryExpiration,
+	))
+
+	w.Send(s, m)
+}
+
+// acceptDiscovery adds all or the selected servers of the guild's pending discovery
+func acceptDiscovery(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
+	pendingDiscoveries.Lock()
+	result, ok := pendingDiscoveries.results[m.GuildID]
+	if ok && result.expired() {
+		delete(pendingDiscoveries.results, m.GuildID)
+		ok = false
+	}
+	pendingDiscoveries.Unlock()
+
+	if !ok {
+		respond(s, m, "there are no discovered servers, please run the discovery first.")
+		return
+	}
+
+	selected := result.infos
+	if args != "" {
+		selected = make([]browser.ServerInfo, 0, len(result.infos))
+		for _, field := range strings.Fields(strings.Replace(args, ",", " ", -1)) {
+			idx, err := strconv.Atoi(field)
+			if err != nil || idx < 1 || idx > len(result.infos) {
+				respond(s, m, fmt.Sprintf("invalid server number: %s", Escape(field)))
+				return
+			}
+			selected = append(selected, result.infos[idx-1])
+		}
+	} else {
+		pendingDiscoveries.Lock()
+		delete(pendingDiscoveries.results, m.GuildID)
+		pendingDiscoveries.Unlock()
+	}
+
+	list := config.Guilds.ServerList(m.GuildID)
+	added := 0
+	for _, info := range selected {
+		if list.Add(info.Address) == nil {
+			added++
+		}
+	}
+	if added > 0 {
+		autosaveServerList(s, m)
+	}
+	respond(s, m, fmt.Sprintf("Added %d servers.", added))
+}
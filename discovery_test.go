@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jxsl13/twapi/browser"
+)
+
+var (
+	// the headers of the fake server responses, see the twapi browser package
+	fakeTokenRequestSize = 4 + 3 + 512
+	fakeServerListHeader = []byte("\xff\xff\xff\xfflis2")
+	fakeServerInfoHeader = []byte("\xff\xff\xff\xffinf3\x00")
+)
+
+// fakeUDPServer answers requests with the response of handle, a nil response is not sent
+func fakeUDPServer(t *testing.T, handle func(request []byte) []byte) (*net.UDPAddr, func()) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if resp := handle(buf[:n]); resp != nil {
+				conn.WriteToUDP(resp, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr), func() { conn.Close() }
+}
+
+// fakeResponse returns the token response to token requests and the data with the header to any other request
+func fakeResponse(request, header, data []byte) []byte {
+	if len(request) == fakeTokenRequestSize {
+		return make([]byte, 12)
+	}
+	resp := append(make([]byte, 9), header...)
+	return append(resp, data...)
+}
+
+// fakeMasterServer lists the servers
+func fakeMasterServer(t *testing.T, servers ...*net.UDPAddr) (*net.UDPAddr, func()) {
+	data := make([]byte, 0, 18*len(servers))
+	for _, srv := range servers {
+		data = append(data, srv.IP.To16()...)
+		data = append(data, byte(srv.Port>>8), byte(srv.Port))
+	}
+	return fakeUDPServer(t, func(request []byte) []byte {
+		return fakeResponse(request, fakeServerListHeader, data)
+	})
+}
+
+// fakeGameServer answers server info requests with the info
+func fakeGameServer(t *testing.T, info browser.ServerInfo) (*net.UDPAddr, func()) {
+	data, err := info.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fakeUDPServer(t, func(request []byte) []byte {
+		return fakeResponse(request, fakeServerInfoHeader, data)
+	})
+}
+
+// unusedUDPAddress returns an address that no server listens on
+func unusedUDPAddress(t *testing.T) *net.UDPAddr {
+	addr, closeServer := fakeUDPServer(t, func([]byte) []byte { return nil })
+	closeServer()
+	return addr
+}
+
+func withResponseTimeout(timeout time.Duration) func() {
+	previous := config.ResponseTimeout
+	config.ResponseTimeout = timeout
+	return func() { config.ResponseTimeout = previous }
+}
+
+func TestDiscovererServerList(t *testing.T) {
+	first := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1).To4(), Port: 8303}
+	second := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 8304}
+
+	ms1, close1 := fakeMasterServer(t, second, first)
+	defer close1()
+	ms2, close2 := fakeMasterServer(t, first)
+	defer close2()
+
+	d := &Discoverer{MasterServers: []*net.UDPAddr{ms1, ms2, unusedUDPAddress(t)}, Timeout: 200 * time.Millisecond}
+	servers, err := d.ServerList()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(servers) != 2 {
+		t.Fatalf("expected 2 servers without duplicates, got %v", servers)
+	}
+	if servers[0].String() != first.String() || servers[1].String() != second.String() {
+		t.Errorf("expected the servers sorted by address, got %v", servers)
+	}
+}
+
+func TestDiscovererServerListNoMasterServer(t *testing.T) {
+	d := &Discoverer{MasterServers: []*net.UDPAddr{unusedUDPAddress(t)}, Timeout: 200 * time.Millisecond}
+	_, err := d.ServerList()
+	if err == nil {
+		t.Fatal("expected an error if no master server responds")
+	}
+
+	d = &Discoverer{}
+	_, err = d.ServerList()
+	if err == nil {
+		t.Fatal("expected an error without master servers")
+	}
+}
+
+func TestDiscovererDiscover(t *testing.T) {
+	defer withResponseTimeout(200 * time.Millisecond)()
+
+	ctf, closeCTF := fakeGameServer(t, browser.ServerInfo{Name: "ctf server", GameType: "CTF", Map: "ctf5", MaxClients: 16})
+	defer closeCTF()
+	dm, closeDM := fakeGameServer(t, browser.ServerInfo{Name: "dm server", GameType: "DM", Map: "dm1", MaxClients: 16})
+	defer closeDM()
+
+	ms, closeMS := fakeMasterServer(t, ctf, dm, unusedUDPAddress(t))
+	defer closeMS()
+
+	d := &Discoverer{MasterServers: []*net.UDPAddr{ms}, Timeout: 200 * time.Millisecond}
+
+	infos, err := d.Discover(&DiscoveryFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("expected the 2 reachable servers, got %v", infos)
+	}
+
+	filter, err := parseDiscoveryFilter("-gametype ctf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	infos, err = d.Discover(filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].Name != "ctf server" || infos[0].Address != ctf.String() {
+		t.Fatalf("expected only the ctf server, got %v", infos)
+	}
+}
+
+func TestDiscovererLimitsConcurrentQueries(t *testing.T) {
+	defer withResponseTimeout(time.Second)()
+
+	const workers = 2
+	var (
+		mu       sync.Mutex
+		inFlight = make(map[int]bool)
+		maxSeen  = 0
+	)
+
+	servers := make([]*net.UDPAddr, 0, 8)
+	for idx := 0; idx < 8; idx++ {
+		idx := idx
+		data, _ := (&browser.ServerInfo{Name: "server", GameType: "DM"}).MarshalBinary()
+		addr, closeServer := fakeUDPServer(t, func(request []byte) []byte {
+			mu.Lock()
+			defer mu.Unlock()
+
+			// a server is queried between its token request and its info request
+			if len(request) == fakeTokenRequestSize {
+				inFlight[idx] = true
+				if len(inFlight) > maxSeen {
+					maxSeen = len(inFlight)
+				}
+				// slow servers keep the workers busy
+				time.Sleep(10 * time.Millisecond)
+			} else {
+				delete(inFlight, idx)
+			}
+			return fakeResponse(request, fakeServerInfoHeader, data)
+		})
+		defer closeServer()
+		servers = append(servers, addr)
+	}
+
+	d := &Discoverer{Workers: workers}
+	infos := d.fetchServerInfos(servers)
+	if len(infos) != len(servers) {
+		t.Fatalf("expected %d infos, got %d", len(servers), len(infos))
+	}
+	for _, info := range infos {
+		if info.Name == "" {
+			t.Errorf("failed to fetch %s", info.Address)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if maxSeen > workers {
+		t.Errorf("expected at most %d servers to be queried at the same time, got %d", workers, maxSeen)
+	}
+}
+
+func TestParseDiscoveryFilter(t *testing.T) {
+	filter, err := parseDiscoveryFilter("-gametype CTF -name ^fng -range 10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.GameType != "ctf" {
+		t.Errorf("expected the gametype to be lower case, got %q", filter.GameType)
+	}
+
+	tests := []struct {
+		info    browser.ServerInfo
+		matches bool
+	}{
+		{browser.ServerInfo{Address: "10.1.2.3:8303", Name: "fng server", GameType: "iCTF"}, true},
+		{browser.ServerInfo{Address: "10.1.2.3:8303", Name: "fng server", GameType: "DM"}, false},
+		{browser.ServerInfo{Address: "10.1.2.3:8303", Name: "my fng server", GameType: "CTF"}, false},
+		{browser.ServerInfo{Address: "11.1.2.3:8303", Name: "fng server", GameType: "CTF"}, false},
+		{browser.ServerInfo{Address: "invalid", Name: "fng server", GameType: "CTF"}, false},
+	}
+	for _, test := range tests {
+		if filter.Matches(test.info) != test.matches {
+			t.Errorf("expected Matches(%v) to be %t", test.info, test.matches)
+		}
+	}
+
+	if !(&DiscoveryFilter{}).Matches(browser.ServerInfo{}) {
+		t.Error("expected an empty filter to match every server")
+	}
+
+	for _, args := range []string{"-gametype", "-name (", "-range 1.2.3", "-unknown x"} {
+		if _, err := parseDiscoveryFilter(args); err == nil {
+			t.Errorf("expected an error for %q", args)
+		}
+	}
+}
+
+func TestParseNetwork(t *testing.T) {
+	tests := []struct {
+		value    string
+		network  string
+		contains string
+	}{
+		{"10.0.0.0/8", "10.0.0.0/8", "10.255.0.1"},
+		{"10.1.2.3/8", "10.0.0.0/8", "10.255.0.1"},
+		{"1.2.3.4", "1.2.3.4/32", "1.2.3.4"},
+		{"2001:db8::1", "2001:db8::1/128", "2001:db8::1"},
+		{"2001:db8::/32", "2001:db8::/32", "2001:db8:ffff::1"},
+	}
+	for _, test := range tests {
+		network, err := parseNetwork(test.value)
+		if err != nil {
+			t.Errorf("%s: %v", test.value, err)
+			continue
+		}
+		if network.String() != test.network {
+			t.Errorf("%s: expected %s, got %s", test.value, test.network, network)
+		}
+		if !network.Contains(net.ParseIP(test.contains)) {
+			t.Errorf("%s: expected %s to contain %s", test.value, network, test.contains)
+		}
+	}
+
+	single, _ := parseNetwork("1.2.3.4")
+	if single.Contains(net.ParseIP("1.2.3.5")) {
+		t.Error("expected a single address not to contain its neighbour")
+	}
+
+	for _, value := range []string{"", "1.2.3", "1.2.3.4/33", "host"} {
+		if _, err := parseNetwork(value); err == nil {
+			t.Errorf("expected an error for %q", value)
+		}
+	}
+}
+
+func TestFakeResponseHeaders(t *testing.T) {
+	// guards the fake servers against changes of the protocol constants
+	resp := fakeResponse([]byte("x"), fakeServerListHeader, nil)
+	match, err := browser.MatchResponse(append(resp, make([]byte, 3)...))
+	if err != nil || match != "serverlist" {
+		t.Fatalf("expected a server list response, got %q: %v", match, err)
+	}
+	if !bytes.Equal(fakeResponse(make([]byte, fakeTokenRequestSize), nil, nil), make([]byte, 12)) {
+		t.Fatal("expected a token response")
+	}
+}
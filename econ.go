@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// econDialTimeout limits connecting and authenticating
+	econDialTimeout = 5 * time.Second
+	// econOutputTimeout is the time without new output after which a command is considered finished
+	econOutputTimeout = 500 * time.Millisecond
+	// econMaxOutputTime limits how long the output of a single command is collected
+	econMaxOutputTime = 5 * time.Second
+
+	econPasswordPrompt = "Enter password:"
+	econAuthSuccess    = "Authentication successful"
+	econAuthFailure    = "Wrong password"
+)
+
+var (
+	// id=0 addr=127.0.0.1:1234 client=0703 name='nameless tee' score=0 secure=no
+	econStatusRegex = regexp.MustCompile(`id=(\d+) .*name='(.*)' score=`)
+
+	errEconNotConfigured = errors.New("the external console of this server is not configured")
+)
+
+// EconServer contains the external console credentials of a registered server
+type EconServer struct {
+	// GuildID is the guild that is allowed to use the credentials
+	GuildID string `json:"guild_id"`
+	// Server is the address or alias of the server in the guild's server list
+	Server   string `json:"server"`
+	Address  string `json:"address"`
+	Password string `json:"password"`
+	// ChatChannelID is the discord channel the in-game chat is bridged to
+	ChatChannelID string `json:"chat_channel_id,omitempty"`
+}
+
+// EconConfig contains the external console credentials of all servers
+type EconConfig struct {
+	Servers []*EconServer `json:"servers"`
+}
+
+// LoadEconConfig reads the external console credentials from the file,
+// a missing file disables the external console commands.
+func LoadEconConfig(filePath string) (*EconConfig, error) {
+	ec := &EconConfig{}
+	data, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return ec, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, ec)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filePath, err)
+	}
+
+	chatChannels := make(map[string]bool)
+	for _, srv := range ec.Servers {
+		if srv.GuildID == "" || srv.Server == "" || srv.Address == "" || srv.Password == "" {
+			return nil, fmt.Errorf("%s: guild_id, server, address and password are required", filePath)
+		}
+		if _, _, err := net.SplitHostPort(srv.Address); err != nil {
+			return nil, fmt.Errorf("%s: invalid address %s: %v", filePath, srv.Address, err)
+		}
+		if srv.ChatChannelID != "" && chatChannels[srv.ChatChannelID] {
+			return nil, fmt.Errorf("%s: the chat channel %s is used by several servers", filePath, srv.ChatChannelID)
+		}
+		chatChannels[srv.ChatChannelID] = true
+	}
+	return ec, nil
+}
+
+// Lookup returns the credentials of the server that is registered in the guild
+func (ec *EconConfig) Lookup(guildID string, entry ServerEntry) (*EconServer, error) {
+	for _, srv := range ec.Servers {
+		if srv.GuildID != guildID {
+			continue
+		}
+		if strings.EqualFold(srv.Server, entry.String()) || (entry.Alias != "" && strings.EqualFold(srv.Server, entry.Alias)) {
+			return srv, nil
+		}
+	}
+	return nil, errEconNotConfigured
+}
+
+// EconClient is a connection to the external console of a teeworlds server
+type EconClient struct {
+	sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// DialEcon connects to the external console at the address and authenticates with the password
+func DialEcon(address, password string) (*EconClient, error) {
+	conn, err := net.DialTimeout("tcp", address, econDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &EconClient{conn: conn, reader: bufio.NewReader(conn)}
+
+	err = c.authenticate(password)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *EconClient) authenticate(password string) error {
+	c.conn.SetDeadline(time.Now().Add(econDialTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	// the prompt is not terminated by a line break
+	prompt := make([]byte, 0, len(econPasswordPrompt))
+	for !strings.Contains(string(prompt), econPasswordPrompt) {
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return fmt.Errorf("failed to read the password prompt: %v", err)
+		}
+		prompt = append(prompt, b)
+	}
+
+	_, err := c.conn.Write([]byte(password + "\n"))
+	if err != nil {
+		return err
+	}
+
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return fmt.Errorf("failed to authenticate: %v", err)
+		}
+		switch {
+		case strings.Contains(line, econAuthSuccess):
+			return nil
+		case strings.Contains(line, econAuthFailure):
+			return errors.New("wrong external console password")
+		}
+	}
+}
+
+// ReadLine returns the next line of the console output without the line break
+func (c *EconClient) ReadLine() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n\x00"), nil
+}
+
+// Send executes the command without waiting for its output
+func (c *EconClient) Send(command string) error {
+	if strings.ContainsAny(command, "\r\n") {
+		return errors.New("the command must not contain line breaks")
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(econDialTimeout))
+	_, err := c.conn.Write([]byte(command + "\n"))
+	return err
+}
+
+// Exec executes the command and returns the console output that follows it.
+// The console does not mark the end of the output, so it is collected until the console is quiet.
+func (c *EconClient) Exec(command string) ([]string, error) {
+	err := c.Send(command)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]string, 0, 8)
+	deadline := time.Now().Add(econMaxOutputTime)
+	for time.Now().Before(deadline) {
+		c.conn.SetReadDeadline(time.Now().Add(econOutputTimeout))
+		line, err := c.ReadLine()
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			break
+		} else if err != nil {
+			return lines, err
+		}
+		lines = append(lines, line)
+	}
+	c.conn.SetReadDeadline(time.Time{})
+	return lines, nil
+}
+
+// Close closes the connection
+func (c *EconClient) Close() error {
+	return c.conn.Close()
+}
+
+// execEcon connects to the external console of the server, executes the commands one after another
+// and returns the output of the last one.
+func execEcon(srv *EconServer, commands ...string) ([]string, error) {
+	c, err := DialEcon(srv.Address, srv.Password)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	var lines []string
+	for _, command := range commands {
+		lines, err = c.Exec(command)
+		if err != nil {
+			return lines, err
+		}
+	}
+	return lines, nil
+}
+
+// econQuote quotes the argument of a console command, otherwise spaces and semicolons would
+// split it into several arguments or commands.
+func econQuote(argument string) string {
+	argument = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\r", " ", "\n", " ").Replace(argument)
+	return fmt.Sprintf("\"%s\"", argument)
+}
+
+// econPlayerID returns the client ID of the player in the output of the status command.
+// The name is matched exactly, if there is no such player, a single case insensitive match is used.
+func econPlayerID(status []string, name string) (string, error) {
+	matches := make([]string, 0, 1)
+	for _, line := range status {
+		m := econStatusRegex.FindStringSubmatch(line)
+		if len(m) != 3 {
+			continue
+		}
+		if m[2] == name {
+			return m[1], nil
+		}
+		if strings.EqualFold(m[2], name) {
+			matches = append(matches, m[1])
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", errors.New("the player is not online")
+	case 1:
+		return matches[0], nil
+	default:
+		return "", errors.New("there are several players with that name, please use the exact name")
+	}
+}
+
+// econTarget looks up the registered server of the first argument and its external console credentials,
+// the remaining arguments are returned as well.
+func econTarget(s *discordgo.Session, m *discordgo.MessageCreate, args, command string) (srv *EconServer, label, remaining string, ok bool) {
+	ss := strings.SplitN(strings.TrimSpace(args), " ", 2)
+	if len(ss) != 2 || strings.TrimSpace(ss[1]) == "" {
+		respond(s, m, usage(m, command))
+		return nil, "", "", false
+	}
+
+	entry, found := config.Guilds.ServerList(m.GuildID).Lookup(ss[0])
+	if !found {
+		respond(s, m, "server is not registered.")
+		return nil, "", "", false
+	}
+
+	srv, err := config.Econ.Lookup(m.GuildID, entry)
+	if err != nil {
+		respond(s, m, err.Error()+".")
+		return nil, "", "", false
+	}
+	return srv, entry.Label(), strings.TrimSpace(ss[1]), true
+}
+
+// respondEconOutput sends the console output as code blocks
+func respondEconOutput(s *discordgo.Session, m *discordgo.MessageCreate, label string, lines []string) {
+	if len(lines) == 0 {
+		respond(s, m, fmt.Sprintf("Executed on %s, there was no output.", Escape(label)))
+		return
+	}
+
+	w := NewOutputWriter()
+	w.WriteLine(codeFence)
+	for _, line := range lines {
+		// code blocks cannot be escaped, so the backticks are separated by a zero width space
+		w.WriteLine(strings.Replace(line, codeFence, "`\u200b`\u200b`", -1))
+	}
+	w.WriteLine(codeFence)
+	w.Send(s, m)
+}
+
+// RconHandler handles the !rcon command that executes a command in the external console of a server
+func RconHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
+	srv, label, command, ok := econTarget(s, m, args, "rcon")
+	if !ok {
+		return
+	}
+
+	log.Printf("%s executes %q on %s\n", m.Author.String(), command, label)
+	lines, err := execEcon(srv, command)
+	if err != nil {
+		log.Printf("failed to execute %q on %s: %v\n", command, label, err)
+		respond(s, m, fmt.Sprintf("Failed to execute the command: %s", Escape(err.Error())))
+		return
+	}
+	respondEconOutput(s, m, label, lines)
+}
+
+// KickHandler handles the !kick command that kicks a player by name
+func KickHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
+	srv, label, name, ok := econTarget(s, m, args, "kick")
+	if !ok {
+		return
+	}
+
+	c, err := DialEcon(srv.Address, srv.Password)
+	if err != nil {
+		log.Printf("failed to connect to the external console of %s: %v\n", label, err)
+		respond(s, m, fmt.Sprintf("Failed to connect to the server: %s", Escape(err.Error())))
+		return
+	}
+	defer c.Close()
+
+	status, err := c.Exec("status")
+	if err != nil {
+		respond(s, m, fmt.Sprintf("Failed to fetch the players: %s", Escape(err.Error())))
+		return
+	}
+	id, err := econPlayerID(status, name)
+	if err != nil {
+		respond(s, m, err.Error()+".")
+		return
+	}
+
+	log.Printf("%s kicks %q (id %s) from %s\n", m.Author.String(), name, id, label)
+	lines, err := c.Exec("kick " + id)
+	if err != nil {
+		respond(s, m, fmt.Sprintf("Failed to kick the player: %s", Escape(err.Error())))
+		return
+	}
+	respondEconOutput(s, m, label, lines)
+}
+
+// MapHandler handles the !map command that changes the map of a server
+func MapHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
+	srv, label, mapName, ok := econTarget(s, m, args, "map")
+	if !ok {
+		return
+	}
+
+	log.Printf("%s changes the map of %s to %q\n", m.Author.String(), label, mapName)
+	lines, err := execEcon(srv, "change_map "+econQuote(mapName))
+	if err != nil {
+		log.Printf("failed to change the map of %s: %v\n", label, err)
+		respond(s, m, fmt.Sprintf("Failed to change the map: %s", Escape(err.Error())))
+		return
+	}
+	respondEconOutput(s, m, label, lines)
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeEconServer accepts external console connections with the password and answers
+// the commands with the lines of responses, unknown commands are answered like the game does.
+type fakeEconServer struct {
+	sync.Mutex
+	listener  net.Listener
+	password  string
+	responses map[string][]string
+	received  []string
+}
+
+func newFakeEconServer(t *testing.T, password string, responses map[string][]string) *fakeEconServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &fakeEconServer{listener: listener, password: password, responses: responses}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(conn)
+		}
+	}()
+	return srv
+}
+
+func (srv *fakeEconServer) Address() string {
+	return srv.listener.Addr().String()
+}
+
+func (srv *fakeEconServer) Close() {
+	srv.listener.Close()
+}
+
+func (srv *fakeEconServer) Received() []string {
+	srv.Lock()
+	defer srv.Unlock()
+	return append([]string(nil), srv.received...)
+}
+
+func (srv *fakeEconServer) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	conn.Write([]byte(econPasswordPrompt + "\n"))
+	password, err := reader.ReadString('\n')
+	if err != nil {
+		return
+	}
+	if strings.TrimSpace(password) != srv.password {
+		conn.Write([]byte(econAuthFailure + " 1/3.\n"))
+		return
+	}
+	conn.Write([]byte(econAuthSuccess + ". External console access granted.\n"))
+
+	for {
+		command, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command = strings.TrimSpace(command)
+
+		srv.Lock()
+		srv.received = append(srv.received, command)
+		lines, ok := srv.responses[command]
+		srv.Unlock()
+
+		if !ok {
+			lines = []string{"[Console]: No such command: " + command + "."}
+		}
+		for _, line := range lines {
+			conn.Write([]byte(line + "\n"))
+		}
+	}
+}
+
+func TestDialEconWrongPassword(t *testing.T) {
+	srv := newFakeEconServer(t, "secret", nil)
+	defer srv.Close()
+
+	_, err := DialEcon(srv.Address(), "wrong")
+	if err == nil || !strings.Contains(err.Error(), "wrong external console password") {
+		t.Fatalf("expected a wrong password error, got %v", err)
+	}
+}
+
+func TestDialEconUnreachable(t *testing.T) {
+	srv := newFakeEconServer(t, "secret", nil)
+	address := srv.Address()
+	srv.Close()
+
+	_, err := DialEcon(address, "secret")
+	if err == nil {
+		t.Fatal("expected an error for a closed port")
+	}
+}
+
+func TestEconClientExec(t *testing.T) {
+	status := []string{
+		"[Server]: id=0 addr=127.0.0.1:1234 client=0703 name='nameless tee' score=3 secure=no",
+		"[Server]: id=1 addr=127.0.0.1:1235 client=0703 name='Tee' score=0 secure=no",
+	}
+	srv := newFakeEconServer(t, "secret", map[string][]string{"status": status})
+	defer srv.Close()
+
+	c, err := DialEcon(srv.Address(), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	lines, err := c.Exec("status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lines, status) {
+		t.Fatalf("expected the status lines %v, got %v", status, lines)
+	}
+
+	// the output of the previous command must not leak into the next one
+	lines, err = c.Exec("unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 1 || !strings.Contains(lines[0], "No such command: unknown") {
+		t.Fatalf("expected the output of the second command, got %v", lines)
+	}
+
+	err = c.Send("say \"a\"\nshutdown")
+	if err == nil {
+		t.Fatal("expected commands with line breaks to be rejected")
+	}
+}
+
+func TestExecEconReturnsLastOutput(t *testing.T) {
+	srv := newFakeEconServer(t, "secret", map[string][]string{
+		"first":  {"first output"},
+		"second": {"second output"},
+	})
+	defer srv.Close()
+
+	lines, err := execEcon(&EconServer{Address: srv.Address(), Password: "secret"}, "first", "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lines, []string{"second output"}) {
+		t.Fatalf("expected the output of the last command, got %v", lines)
+	}
+	if received := srv.Received(); !reflect.DeepEqual(received, []string{"first", "second"}) {
+		t.Fatalf("expected both commands to be executed, got %v", received)
+	}
+}
+
+func TestEconQuoteKeepsASingleArgument(t *testing.T) {
+	srv := newFakeEconServer(t, "secret", nil)
+	defer srv.Close()
+
+	_, err := execEcon(&EconServer{Address: srv.Address(), Password: "secret"}, "change_map "+econQuote("dm1\"; shutdown\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := srv.Received()
+	if len(received) != 1 {
+		t.Fatalf("expected a single command, got %v", received)
+	}
+	if received[0] != `change_map "dm1\"; shutdown "` {
+		t.Fatalf("expected the map name to be quoted, got %s", received[0])
+	}
+}
+
+func TestEconPlayerID(t *testing.T) {
+	status := []string{
+		"[Server]: id=0 addr=127.0.0.1:1234 client=0703 name='nameless tee' score=3 secure=no",
+		"[Server]: id=3 addr=127.0.0.1:1235 client=0703 name='Tee' score=0 secure=no",
+		"[Server]: id=5 addr=127.0.0.1:1236 client=0703 name='tee' score=0 secure=no",
+		"[Server]: id=7 addr=127.0.0.1:1237 client=0703 name='brainless tee' score=0 secure=no",
+		"[Console]: not a status line",
+	}
+
+	tests := []struct {
+		name string
+		id   string
+		ok   bool
+	}{
+		{"Tee", "3", true},
+		{"tee", "5", true},
+		{"NAMELESS TEE", "0", true},
+		{"TEE", "", false},
+		{"missing", "", false},
+	}
+	for _, test := range tests {
+		id, err := econPlayerID(status, test.name)
+		if (err == nil) != test.ok || id != test.id {
+			t.Errorf("%s: expected id %q (ok %t), got %q: %v", test.name, test.id, test.ok, id, err)
+		}
+	}
+}
+
+func writeEconConfig(t *testing.T, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "econ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filePath := filepath.Join(dir, "econ.json")
+	err = ioutil.WriteFile(filePath, []byte(content), 0600)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return filePath, func() { os.RemoveAll(dir) }
+}
+
+func TestLoadEconConfig(t *testing.T) {
+	ec, err := LoadEconConfig(filepath.Join(os.TempDir(), "missing-econ.json"))
+	if err != nil || len(ec.Servers) != 0 {
+		t.Fatalf("expected a missing file to disable the external console, got %v: %v", ec, err)
+	}
+
+	invalid := []string{
+		`{"servers": [{"server": "ger1", "address": "127.0.0.1:8403", "password": "pw"}]}`,
+		`{"servers": [{"guild_id": "1", "server": "ger1", "address": "127.0.0.1", "password": "pw"}]}`,
+		`{"servers": [
+			{"guild_id": "1", "server": "ger1", "address": "127.0.0.1:8403", "password": "pw", "chat_channel_id": "5"},
+			{"guild_id": "1", "server": "ger2", "address": "127.0.0.1:8404", "password": "pw", "chat_channel_id": "5"}
+		]}`,
+		`{"servers": `,
+	}
+	for _, content := range invalid {
+		filePath, cleanup := writeEconConfig(t, content)
+		_, err := LoadEconConfig(filePath)
+		cleanup()
+		if err == nil {
+			t.Errorf("expected an error for %s", content)
+		}
+	}
+}
+
+func TestEconConfigLookupIsBoundToTheGuild(t *testing.T) {
+	filePath, cleanup := writeEconConfig(t, `{"servers": [
+		{"guild_id": "1", "server": "ger1", "address": "127.0.0.1:8403", "password": "first"},
+		{"guild_id": "2", "server": "1.2.3.4:8303", "address": "127.0.0.1:8404", "password": "second"}
+	]}`)
+	defer cleanup()
+
+	ec, err := LoadEconConfig(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byAlias, err := parseServerLine("1.2.3.4:8303 alias=ger1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = byAlias.resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv, err := ec.Lookup("1", *byAlias)
+	if err != nil || srv.Password != "first" {
+		t.Fatalf("expected the credentials of guild 1, got %v: %v", srv, err)
+	}
+
+	// guild 2 registered the same server, but must not use the credentials of guild 1
+	srv, err = ec.Lookup("2", *byAlias)
+	if err != nil || srv.Password != "second" {
+		t.Fatalf("expected the credentials of guild 2, got %v: %v", srv, err)
+	}
+
+	for _, guildID := range []string{"3", ""} {
+		if _, err := ec.Lookup(guildID, *byAlias); err != errEconNotConfigured {
+			t.Errorf("expected guild %q not to use other guilds' credentials, got %v", guildID, err)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jxsl13/twapi/browser"
+)
+
+// limits of discord embeds, the sizes are counted in bytes, which is never less than the number of characters
+const (
+	maxEmbedTitle      = 256
+	maxEmbedFields     = 25
+	maxEmbedFieldValue = 1024
+	// all embeds of a message must not exceed 6000 characters together
+	maxEmbedsSize     = 6000
+	maxEmbedsPerMsg   = 10
+	embedFieldSpacing = "\u200b"
+
+	embedColorEmpty = 0x99aab5
+	embedColorLow   = 0x43b581
+	embedColorHigh  = 0xfaa61a
+	embedColorFull  = 0xf04747
+)
+
+// embedColor returns a color that shows how full the server is
+func embedColor(numClients, maxClients int) int {
+	switch {
+	case numClients == 0 || maxClients <= 0:
+		return embedColorEmpty
+	case numClients >= maxClients:
+		return embedColorFull
+	case float64(numClients)/float64(maxClients) >= 0.75:
+		return embedColorHigh
+	default:
+		return embedColorLow
+	}
+}
+
+// embedSize returns the size of the embed that counts towards the limit of a message
+func embedSize(e *discordgo.MessageEmbed) int {
+	size := len(e.Title) + len(e.Description)
+	if e.Footer != nil {
+		size += len(e.Footer.Text)
+	}
+	if e.Author != nil {
+		size += len(e.Author.Name)
+	}
+	for _, field := range e.Fields {
+		size += len(field.Name) + len(field.Value)
+	}
+	return size
+}
+
+// truncate cuts the text off after max bytes without splitting a character
+func truncate(text string, max int) string {
+	if len(text) <= max {
+		return text
+	}
+	runes := []rune(text)
+	for len(string(runes)) > max-3 {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
+
+// embedFooter marks the embed with the time of the last poll
+func embedFooter(e *discordgo.MessageEmbed, updatedAt time.Time) {
+	e.Footer = &discordgo.MessageEmbedFooter{Text: "last updated"}
+	e.Timestamp = updatedAt.Format(time.RFC3339)
+}
+
+// appendEmbedLines adds the lines as fields to the embed. Lines that do not fit into the embed are added to
+// continuations of the embed that are created by next. All filled embeds are returned.
+func appendEmbedLines(e *discordgo.MessageEmbed, fieldName string, lines []string, next func() *discordgo.MessageEmbed) []*discordgo.MessageEmbed {
+	embeds := make([]*discordgo.MessageEmbed, 0, 1)
+	field := &discordgo.MessageEmbedField{Name: fieldName}
+
+	addField := func() {
+		if field.Value == "" {
+			return
+		}
+		if len(e.Fields) >= maxEmbedFields || embedSize(e)+len(field.Name)+len(field.Value) > maxEmbedsSize {
+			embeds = append(embeds, e)
+			e = next()
+		}
+		e.Fields = append(e.Fields, field)
+		field = &discordgo.MessageEmbedField{Name: embedFieldSpacing}
+	}
+
+	for _, line := range lines {
+		line = truncate(line, maxEmbedFieldValue)
+		if len(field.Value)+len(line) > maxEmbedFieldValue {
+			addField()
+		}
+		field.Value += line
+	}
+	addField()
+
+	return append(embeds, e)
+}
+
+// onlineEmbeds creates an embed for every server that has players playing the given gametype.
+// Servers with many players are continued in further embeds.
+func onlineEmbeds(list *ConcurrentServerList, infos []browser.ServerInfo, updatedAt time.Time, gametype string, extended bool) []*discordgo.MessageEmbed {
+	servers := onlineServers(infos, gametype)
+
+	embeds := make([]*discordgo.MessageEmbed, 0, len(servers))
+	for _, server := range servers {
+		server := server
+		newEmbed := func() *discordgo.MessageEmbed {
+			e := &discordgo.MessageEmbed{
+				Title:       truncate(server.Name, maxEmbedTitle),
+				Description: Escape(list.DisplayAddress(server.Address)) + aliasSuffix(list, server),
+				Color:       embedColor(server.NumClients, server.MaxClients),
+			}
+			embedFooter(e, updatedAt)
+			return e
+		}
+
+		e := newEmbed()
+		e.Fields = []*discordgo.MessageEmbedField{
+			{Name: "Map", Value: truncate(Escape(server.Map), maxEmbedFieldValue), Inline: true},
+			{Name: "Gametype", Value: truncate(Escape(server.GameType), maxEmbedFieldValue), Inline: true},
+			{Name: "Players", Value: fmt.Sprintf("%d/%d", server.NumClients, server.MaxClients), Inline: true},
+		}
+
+		lines := compactPlayerLines(server.Players)
+		if extended {
+			lines = extendedPlayerLines(server)
+		}
+		embeds = append(embeds, appendEmbedLines(e, embedFieldSpacing, lines, func() *discordgo.MessageEmbed {
+			e := newEmbed()
+			e.Title = truncate(server.Name+" (continued)", maxEmbedTitle)
+			return e
+		})...)
+	}
+	return embeds
+}
+
+// serversEmbeds lists the servers as fields of as few embeds as possible
+func serversEmbeds(list *ConcurrentServerList, infos []browser.ServerInfo, updatedAt time.Time, title string) []*discordgo.MessageEmbed {
+	newEmbed := func() *discordgo.MessageEmbed {
+		e := &discordgo.MessageEmbed{Title: truncate(title, maxEmbedTitle)}
+		embedFooter(e, updatedAt)
+		return e
+	}
+
+	e := newEmbed()
+	embeds := make([]*discordgo.MessageEmbed, 0, 1)
+	for _, server := range infos {
+		entry, _ := list.Resolved(server.Address)
+
+		field := &discordgo.MessageEmbedField{}
+		value := Escape(list.DisplayAddress(server.Address)) + aliasSuffix(list, server)
+		if server.Name == "" {
+			field.Name = "Failed to fetch"
+		} else {
+			field.Name = truncate(server.Name, maxEmbedTitle)
+			value += fmt.Sprintf("\nMap: **%s** (%d/%d) %s", Escape(server.Map), server.NumClients, server.MaxClients, Escape(server.GameType))
+		}
+		if entry.Description != "" {
+			value += "\n" + Escape(entry.Description)
+		}
+		if entry.Hidden {
+			value += "\n*(hidden)*"
+		}
+		field.Value = truncate(value, maxEmbedFieldValue)
+
+		if len(e.Fields) >= maxEmbedFields || embedSize(e)+len(field.Name)+len(field.Value) > maxEmbedsSize {
+			embeds = append(embeds, e)
+			e = newEmbed()
+		}
+		e.Fields = append(e.Fields, field)
+	}
+	return append(embeds, e)
+}
+
+// embedMessages distributes the embeds over as few messages as possible
+func embedMessages(embeds []*discordgo.MessageEmbed) []*discordgo.MessageSend {
+	messages := make([]*discordgo.MessageSend, 0, 1)
+	current := &discordgo.MessageSend{}
+	size := 0
+
+	for _, e := range embeds {
+		if len(current.Embeds) > 0 && (len(current.Embeds) >= maxEmbedsPerMsg || size+embedSize(e) > maxEmbedsSize) {
+			messages = append(messages, current)
+			current = &discordgo.MessageSend{}
+			size = 0
+		}
+		current.Embeds = append(current.Embeds, e)
+		size += embedSize(e)
+	}
+	if len(current.Embeds) > 0 {
+		messages = append(messages, current)
+	}
+	return messages
+}
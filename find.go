@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jxsl13/twapi/browser"
+)
+
+const (
+	// the lower the better
+	matchExact = iota
+	matchPrefix
+	matchSubstring
+	matchSubsequence
+	matchTypo
+	noMatch
+
+	// maxFindResults limits the number of players that are listed by !find
+	maxFindResults = 50
+)
+
+// playerMatch is a player that was found by !find
+type playerMatch struct {
+	Player  browser.PlayerInfo
+	Server  browser.ServerInfo
+	Quality int
+}
+
+// FindHandler handles the !find command that searches for players on all registered servers.
+func FindHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
+	query := strings.ToLower(strings.TrimSpace(args))
+	if query == "" {
+		respond(s, m, usage(m, "find"))
+		return
+	}
+
+	list := config.Guilds.ServerList(m.GuildID)
+	infos, updatedAt := guildSnapshot(m.GuildID)
+	if updatedAt.IsZero() {
+		respond(s, m, errCacheEmpty)
+		return
+	}
+	infos = visibleServerInfos(list, infos, "")
+
+	matches := findPlayers(infos, query)
+	if len(matches) == 0 {
+		respond(s, m, fmt.Sprintf("no players found, last updated %s.", formatAge(updatedAt)))
+		return
+	}
+
+	w := NewOutputWriter()
+	for idx, match := range matches {
+		if idx == maxFindResults {
+			w.WriteLine(fmt.Sprintf("... and %d more, please be more specific.", len(matches)-maxFindResults))
+			break
+		}
+
+		inlineCode := WrapInInlineCodeBlock(fmt.Sprintf("%-20s %-16s", match.Player.Name, match.Player.Clan))
+		w.WriteLine(fmt.Sprintf("%s %s %s on **%s** (%s) Map: **%s**",
+			Flag(match.Player.Country),
+			inlineCode,
+			playerTeam(match.Player),
+			Escape(match.Server.Name),
+			Escape(list.DisplayAddress(match.Server.Address)),
+			Escape(match.Server.Map),
+		))
+	}
+	w.WriteLine(fmt.Sprintf("*last updated %s*", formatAge(updatedAt)))
+
+	w.Send(s, m)
+}
+
+// findPlayers returns all players whose name or clan matches the lowercase query, best matches first.
+func findPlayers(infos []browser.ServerInfo, query string) (matches []playerMatch) {
+	for _, server := range infos {
+		for _, player := range server.Players {
+			quality := matchQuality(player.Name, query)
+			if clanQuality := matchQuality(player.Clan, query); clanQuality < quality {
+				quality = clanQuality
+			}
+
+			if quality == noMatch {
+				continue
+			}
+			matches = append(matches, playerMatch{Player: player, Server: server, Quality: quality})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Quality != matches[j].Quality {
+			return matches[i].Quality < matches[j].Quality
+		}
+		return strings.ToLower(matches[i].Player.Name) < strings.ToLower(matches[j].Player.Name)
+	})
+	return
+}
+
+// matchQuality compares a name against a lowercase query
+func matchQuality(name, query string) int {
+	name = strings.ToLower(name)
+
+	switch {
+	case name == "":
+		return noMatch
+	case name == query:
+		return matchExact
+	case strings.HasPrefix(name, query):
+		return matchPrefix
+	case strings.Contains(name, query):
+		return matchSubstring
+	case len([]rune(query)) >= 3 && isSubsequence(name, query):
+		return matchSubsequence
+	case len([]rune(query)) >= 4 && levenshtein(name, query) <= 2:
+		return matchTypo
+	default:
+		return noMatch
+	}
+}
+
+// isSubsequence returns true if all characters of query appear in name in the same order.
+func isSubsequence(name, query string) bool {
+	q := []rune(query)
+	if len(q) == 0 {
+		return true
+	}
+
+	idx := 0
+	for _, r := range name {
+		if r == q[idx] {
+			idx++
+			if idx == len(q) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// playerTeam returns whether the player is playing or spectating
+func playerTeam(player browser.PlayerInfo) string {
+	if player.Type&playerFlagSpectator != 0 {
+		return "spectating"
+	}
+	return "playing"
+}
@@ -3,7 +3,8 @@ module github.com/jxsl13/TeeworldsDiscordBotGo
 go 1.13
 
 require (
-	github.com/bwmarrin/discordgo v0.20.2
+	github.com/bwmarrin/discordgo v0.27.1
 	github.com/joho/godotenv v1.3.0
 	github.com/jxsl13/twapi v0.0.0-20200216164944-3435b00c1dac
+	golang.org/x/image v0.0.0-20200119044424-58c23975cae1
 )
@@ -0,0 +1,602 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jxsl13/twapi/browser"
+)
+
+const (
+	guildsFile = "guilds.json"
+
+	// guildServersDir contains the server files of all guilds except for the default guild
+	guildServersDir = "servers"
+
+	defaultPrefix   = "!"
+	maxPrefixLength = 5
+)
+
+var (
+	channelMentionRegex = regexp.MustCompile(`^<#(\d+)>$`)
+)
+
+// GuildConfig contains the settings of a single discord guild
+type GuildConfig struct {
+	Prefix string `json:"prefix,omitempty"`
+	// GameTypeFilter overrides the DEFAULT_GAMETYPE_FILTER if set, an empty filter shows all gametypes.
+	GameTypeFilter *string `json:"gametype_filter,omitempty"`
+	// LogChannelID is the channel that changes of the server list are posted to
+	LogChannelID string `json:"log_channel_id,omitempty"`
+	// PlainText replaces the embeds of !online and !servers with markdown text
+	PlainText bool `json:"plain_text,omitempty"`
+	Permissions
+
+	serverList *ConcurrentServerList
+}
+
+// LoadGuildConfigs reads the guild settings from the data directory.
+// The default guild uses defaultServerList, if no default guild ID is given,
+// all guilds share the default server list.
+// owners are user IDs or legacy username#discriminator strings that are always admins in every guild.
+func LoadGuildConfigs(defaultGuildID string, defaultServerList *ConcurrentServerList, owners []string) (*GuildConfigs, error) {
+	g := &GuildConfigs{
+		Guilds:            make(map[string]*GuildConfig),
+		defaultGuildID:    defaultGuildID,
+		defaultServerList: defaultServerList,
+		owners:            make(map[string]bool, len(owners)),
+	}
+
+	err := loadJSON(guildsFile, g)
+	if err != nil {
+		return nil, err
+	}
+	if g.Guilds == nil {
+		g.Guilds = make(map[string]*GuildConfig)
+	}
+
+	for _, owner := range owners {
+		owner = strings.TrimSpace(owner)
+		if owner != "" {
+			g.owners[owner] = true
+		}
+	}
+
+	err = g.migratePermissions()
+	if err != nil {
+		return nil, err
+	}
+
+	for guildID, gc := range g.Guilds {
+		if g.isDefault(guildID) {
+			continue
+		}
+		gc.serverList, err = loadGuildServerFile(g.serverFile(guildID))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// migratePermissions moves the globally granted permissions to the default guild.
+// Without a default guild the permissions cannot be assigned to a guild, the file is kept until one is set.
+func (g *GuildConfigs) migratePermissions() error {
+	legacyPermissions := Permissions{}
+	err := loadJSON(permissionsFile, &legacyPermissions)
+	if err != nil {
+		return err
+	}
+	if len(legacyPermissions.Users) == 0 && len(legacyPermissions.Roles) == 0 {
+		return nil
+	}
+	if g.defaultGuildID == "" {
+		log.Printf("the permissions of %s are not used, set DEFAULT_GUILD_ID to move them into %s\n", permissionsFile, guildsFile)
+		return nil
+	}
+
+	gc := g.get(g.defaultGuildID)
+	if gc.Users == nil {
+		gc.Users = legacyPermissions.Users
+	} else if len(legacyPermissions.Users) > 0 {
+		log.Printf("dropped the user permissions of %s, the guild %s already has its own\n", permissionsFile, g.defaultGuildID)
+	}
+	if gc.Roles == nil {
+		gc.Roles = legacyPermissions.Roles
+	} else if len(legacyPermissions.Roles) > 0 {
+		log.Printf("dropped the role permissions of %s, the guild %s already has its own\n", permissionsFile, g.defaultGuildID)
+	}
+
+	err = g.save()
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filepath.Join(config.DataDir, permissionsFile))
+	if err != nil {
+		return err
+	}
+	log.Printf("moved the permissions of %s into %s\n", permissionsFile, guildsFile)
+	return nil
+}
+
+// loadGuildServerFile is like loadServerFile, but a missing file results in an empty list.
+func loadGuildServerFile(filePath string) (*ConcurrentServerList, error) {
+	list, err := loadServerFile(filePath)
+	if os.IsNotExist(err) {
+		return NewConcurrentServerList(0), nil
+	}
+	return list, err
+}
+
+// GuildConfigs maps guild IDs to their settings
+type GuildConfigs struct {
+	sync.Mutex
+	Guilds map[string]*GuildConfig `json:"guilds"`
+
+	defaultGuildID    string
+	defaultServerList *ConcurrentServerList
+	owners            map[string]bool
+}
+
+// save must be called while holding the lock
+func (g *GuildConfigs) save() error {
+	return saveJSON(guildsFile, g)
+}
+
+// key returns the key of the guild's config, direct messages use the default guild.
+func (g *GuildConfigs) key(guildID string) string {
+	if guildID == "" {
+		return g.defaultGuildID
+	}
+	return guildID
+}
+
+// isDefault returns true if the guild uses the default server list
+func (g *GuildConfigs) isDefault(guildID string) bool {
+	return g.defaultGuildID == "" || g.key(guildID) == g.defaultGuildID
+}
+
+// get must be called while holding the lock, it creates missing guild configs
+func (g *GuildConfigs) get(guildID string) *GuildConfig {
+	key := g.key(guildID)
+	gc, ok := g.Guilds[key]
+	if !ok {
+		gc = &GuildConfig{}
+		g.Guilds[key] = gc
+	}
+	return gc
+}
+
+// serverFile returns the path of the file the guild's servers are saved to
+func (g *GuildConfigs) serverFile(guildID string) string {
+	if g.isDefault(guildID) {
+		return config.FilePath
+	}
+	return filepath.Join(config.DataDir, guildServersDir, g.key(guildID)+".txt")
+}
+
+// ServerFile returns the path of the file the guild's servers are saved to
+func (g *GuildConfigs) ServerFile(guildID string) string {
+	g.Lock()
+	defer g.Unlock()
+
+	return g.serverFile(guildID)
+}
+
+// ServerList returns the servers that are registered in a guild
+func (g *GuildConfigs) ServerList(guildID string) *ConcurrentServerList {
+	g.Lock()
+	defer g.Unlock()
+
+	if g.isDefault(guildID) {
+		return g.defaultServerList
+	}
+
+	gc, ok := g.Guilds[g.key(guildID)]
+	if ok && gc.serverList != nil {
+		return gc.serverList
+	}
+
+	// new guild, remember it in order to load its server file on startup
+	gc = g.get(guildID)
+	gc.serverList = NewConcurrentServerList(0)
+	err := g.save()
+	if err != nil {
+		log.Printf("failed to save guild configs: %v\n", err)
+	}
+	return gc.serverList
+}
+
+// ServerLists returns the server lists of all guilds
+func (g *GuildConfigs) ServerLists() []*ConcurrentServerList {
+	g.Lock()
+	defer g.Unlock()
+
+	lists := []*ConcurrentServerList{g.defaultServerList}
+	for _, gc := range g.Guilds {
+		if gc.serverList != nil {
+			lists = append(lists, gc.serverList)
+		}
+	}
+	return lists
+}
+
+// ServerFiles maps the server file paths of all guilds to their server lists
+func (g *GuildConfigs) ServerFiles() map[string]*ConcurrentServerList {
+	g.Lock()
+	defer g.Unlock()
+
+	files := map[string]*ConcurrentServerList{config.FilePath: g.defaultServerList}
+	for guildID, gc := range g.Guilds {
+		if gc.serverList != nil && !g.isDefault(guildID) {
+			files[g.serverFile(guildID)] = gc.serverList
+		}
+	}
+	return files
+}
+
+// LogChannels returns the log channels of all guilds that use the server list
+func (g *GuildConfigs) LogChannels(list *ConcurrentServerList) []string {
+	g.Lock()
+	defer g.Unlock()
+
+	channelIDs := make([]string, 0, 1)
+	for guildID, gc := range g.Guilds {
+		if gc.LogChannelID == "" {
+			continue
+		}
+		if (g.isDefault(guildID) && list == g.defaultServerList) || gc.serverList == list {
+			channelIDs = append(channelIDs, gc.LogChannelID)
+		}
+	}
+	sort.Strings(channelIDs)
+	return channelIDs
+}
+
+// SetLogChannel changes the channel that changes of the server list are posted to, an empty ID disables it.
+func (g *GuildConfigs) SetLogChannel(guildID, channelID string) error {
+	g.Lock()
+	defer g.Unlock()
+
+	g.get(guildID).LogChannelID = channelID
+	return g.save()
+}
+
+// AllServers returns the resolved servers of all guilds without duplicates
+func (g *GuildConfigs) AllServers() []*net.UDPAddr {
+	set := make(map[string]bool)
+	servers := make([]*net.UDPAddr, 0, g.defaultServerList.Len())
+	for _, list := range g.ServerLists() {
+		for _, addr := range list.List() {
+			if set[addr.String()] {
+				continue
+			}
+			set[addr.String()] = true
+			servers = append(servers, addr)
+		}
+	}
+	return servers
+}
+
+// GameTypeFilter returns the gametype that is shown by !online if no gametype is given
+func (g *GuildConfigs) GameTypeFilter(guildID string) string {
+	g.Lock()
+	defer g.Unlock()
+
+	gc, ok := g.Guilds[g.key(guildID)]
+	if !ok || gc.GameTypeFilter == nil {
+		return config.DefaultGameTypeFilter
+	}
+	return *gc.GameTypeFilter
+}
+
+// SetGameTypeFilter changes the guild's default gametype filter, nil resets it to DEFAULT_GAMETYPE_FILTER.
+func (g *GuildConfigs) SetGameTypeFilter(guildID string, filter *string) error {
+	g.Lock()
+	defer g.Unlock()
+
+	g.get(guildID).GameTypeFilter = filter
+	return g.save()
+}
+
+// PlainText returns true if the guild prefers markdown text over embeds
+func (g *GuildConfigs) PlainText(guildID string) bool {
+	g.Lock()
+	defer g.Unlock()
+
+	gc, ok := g.Guilds[g.key(guildID)]
+	return ok && gc.PlainText
+}
+
+// SetPlainText changes whether the guild prefers markdown text over embeds
+func (g *GuildConfigs) SetPlainText(guildID string, plainText bool) error {
+	g.Lock()
+	defer g.Unlock()
+
+	g.get(guildID).PlainText = plainText
+	return g.save()
+}
+
+// Prefix returns the command prefix of a guild
+func (g *GuildConfigs) Prefix(guildID string) string {
+	g.Lock()
+	defer g.Unlock()
+
+	gc, ok := g.Guilds[g.key(guildID)]
+	if !ok || gc.Prefix == "" {
+		return defaultPrefix
+	}
+	return gc.Prefix
+}
+
+// SetPrefix changes the command prefix of a guild
+func (g *GuildConfigs) SetPrefix(guildID, prefix string) error {
+	if guildID == "" {
+		return errors.New("the prefix can only be changed in a guild")
+	}
+	if prefix == "" || len(prefix) > maxPrefixLength {
+		return fmt.Errorf("the prefix must be between 1 and %d characters long", maxPrefixLength)
+	}
+	for _, r := range prefix {
+		if unicode.IsSpace(r) {
+			return errors.New("the prefix must not contain any whitespace")
+		}
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	g.get(guildID).Prefix = prefix
+	if prefix == defaultPrefix {
+		g.get(guildID).Prefix = ""
+	}
+	return g.save()
+}
+
+// Level returns the highest permission level of a user and their roles within a guild
+func (g *GuildConfigs) Level(guildID string, user *discordgo.User, roleIDs []string) PermissionLevel {
+	if user == nil {
+		return PermissionNone
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	if g.owners[user.ID] || g.owners[user.String()] {
+		return PermissionAdmin
+	}
+
+	gc, ok := g.Guilds[g.key(guildID)]
+	if !ok {
+		return PermissionNone
+	}
+	return gc.level(user.ID, roleIDs)
+}
+
+// Grant sets the permission level of a user or role mention within a guild, PermissionNone revokes the permissions.
+func (g *GuildConfigs) Grant(guildID, mention string, level PermissionLevel) error {
+	g.Lock()
+	defer g.Unlock()
+
+	isRole, id, err := parsePermissionTarget(mention)
+	if err != nil {
+		return err
+	}
+	if !isRole && g.owners[id] {
+		return errors.New("the permissions of the bot owner cannot be changed")
+	}
+
+	err = g.get(guildID).grant(mention, level)
+	if err != nil {
+		return err
+	}
+	return g.save()
+}
+
+// FormatPermissions returns a list of the bot owners and all permissions granted within a guild
+func (g *GuildConfigs) FormatPermissions(s *discordgo.Session, guildID string) string {
+	g.Lock()
+	defer g.Unlock()
+
+	lines := make([]string, 0, len(g.owners))
+	for owner := range g.owners {
+		lines = append(lines, fmt.Sprintf("%s owner", userName(s, guildID, owner)))
+	}
+	if gc, ok := g.Guilds[g.key(guildID)]; ok {
+		lines = append(lines, gc.format(s, guildID)...)
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// guildSnapshot returns the cached server infos of the servers that are registered in the guild
+func guildSnapshot(guildID string) (infos []browser.ServerInfo, updatedAt time.Time) {
+	infos, updatedAt = config.ServerInfos.Snapshot()
+	return guildServerInfos(guildID, infos), updatedAt
+}
+
+// guildServerInfos filters the server infos of the servers that are registered in the guild
+func guildServerInfos(guildID string, infos []browser.ServerInfo) []browser.ServerInfo {
+	list := config.Guilds.ServerList(guildID)
+
+	registered := make(map[string]bool, list.Len())
+	for _, addr := range list.List() {
+		registered[addr.String()] = true
+	}
+
+	filtered := make([]browser.ServerInfo, 0, len(registered))
+	for _, info := range infos {
+		if registered[info.Address] {
+			filtered = append(filtered, info)
+		}
+	}
+	return filtered
+}
+
+// visibleServerInfos drops the infos of hidden servers and of servers that are not part of the group,
+// an empty group matches every server.
+func visibleServerInfos(list *ConcurrentServerList, infos []browser.ServerInfo, group string) []browser.ServerInfo {
+	filtered := make([]browser.ServerInfo, 0, len(infos))
+	for _, info := range infos {
+		entry, ok := list.Resolved(info.Address)
+		if !ok || entry.Hidden || (group != "" && !entry.InGroup(group)) {
+			continue
+		}
+		filtered = append(filtered, info)
+	}
+	return filtered
+}
+
+// mentionedChannel returns the channel of the guild that is mentioned in the argument,
+// the current channel if the argument is empty or an empty ID for off.
+func mentionedChannel(s *discordgo.Session, m *discordgo.MessageCreate, argument string) (string, error) {
+	switch {
+	case strings.ToLower(argument) == "off":
+		return "", nil
+	case argument == "":
+		return m.ChannelID, nil
+	}
+
+	matches := channelMentionRegex.FindStringSubmatch(argument)
+	if len(matches) != 2 {
+		return "", errors.New("invalid channel, please mention the channel like #channel.")
+	}
+	if channel, err := s.Channel(matches[1]); err != nil || channel.GuildID != m.GuildID {
+		return "", errors.New("unknown channel.")
+	}
+	return matches[1], nil
+}
+
+// stripCommandPrefix removes the guild's command prefix or a mention of the bot from the line.
+// ok is false if the line does not start with either of them.
+func stripCommandPrefix(s *discordgo.Session, m *discordgo.MessageCreate, line string) (command string, ok bool) {
+	botID := s.State.User.ID
+	for _, mention := range []string{"<@" + botID + ">", "<@!" + botID + ">"} {
+		if strings.HasPrefix(line, mention) {
+			return strings.TrimSpace(line[len(mention):]), true
+		}
+	}
+
+	prefix := config.Guilds.Prefix(m.GuildID)
+	if strings.HasPrefix(line, prefix) {
+		return line[len(prefix):], true
+	}
+	return "", false
+}
+
+// PrefixHandler handles the !prefix command that shows or changes the guild's command prefix
+func PrefixHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
+	prefix := strings.TrimSpace(args)
+	if prefix == "" {
+		respond(s, m, fmt.Sprintf("The command prefix is %s", WrapInInlineCodeBlock(config.Guilds.Prefix(m.GuildID))))
+		return
+	}
+
+	if authorLevel(m) < PermissionAdmin {
+		respond(s, m, "you are not allowed to access this command.")
+		return
+	}
+
+	err := config.Guilds.SetPrefix(m.GuildID, prefix)
+	if err != nil {
+		respond(s, m, err.Error())
+		return
+	}
+	respond(s, m, fmt.Sprintf("The command prefix was changed to %s", WrapInInlineCodeBlock(prefix)))
+}
+
+// LogChannelHandler handles the !logchannel command that changes where changes of the server list are posted to
+func LogChannelHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
+	argument := strings.TrimSpace(args)
+	if m.GuildID == "" {
+		respond(s, m, "the log channel can only be changed in a guild.")
+		return
+	}
+
+	channelID, err := mentionedChannel(s, m, argument)
+	if err != nil {
+		respond(s, m, err.Error())
+		return
+	}
+
+	err = config.Guilds.SetLogChannel(m.GuildID, channelID)
+	if err != nil {
+		respond(s, m, "Failed to save the guild settings.")
+		return
+	}
+
+	if channelID == "" {
+		respond(s, m, "Changes of the server list are not posted anymore.")
+		return
+	}
+	respond(s, m, fmt.Sprintf("Changes of the server list are posted to <#%s>.", channelID))
+}
+
+// FilterHandler handles the !filter command that shows or changes the guild's default gametype filter
+func FilterHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
+	filter := strings.ToLower(strings.TrimSpace(args))
+
+	var err error
+	switch filter {
+	case "":
+		current := config.Guilds.GameTypeFilter(m.GuildID)
+		if current == "" {
+			respond(s, m, "All gametypes are shown by default.")
+		} else {
+			respond(s, m, fmt.Sprintf("The default gametype filter is %s", WrapInInlineCodeBlock(current)))
+		}
+		return
+	case "reset":
+		err = config.Guilds.SetGameTypeFilter(m.GuildID, nil)
+	case "off":
+		filter = ""
+		err = config.Guilds.SetGameTypeFilter(m.GuildID, &filter)
+	default:
+		err = config.Guilds.SetGameTypeFilter(m.GuildID, &filter)
+	}
+
+	if err != nil {
+		respond(s, m, "Failed to save the guild settings.")
+		return
+	}
+	respond(s, m, "Changed the default gametype filter.")
+}
+
+// OutputHandler handles the !output command that shows or changes whether !online and !servers use embeds
+func OutputHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
+	var plainText bool
+	switch strings.ToLower(strings.TrimSpace(args)) {
+	case "":
+		if config.Guilds.PlainText(m.GuildID) {
+			respond(s, m, "The servers are shown as text.")
+		} else {
+			respond(s, m, "The servers are shown as embeds.")
+		}
+		return
+	case "embed", "embeds":
+		plainText = false
+	case "text":
+		plainText = true
+	default:
+		respond(s, m, usage(m, "output"))
+		return
+	}
+
+	err := config.Guilds.SetPlainText(m.GuildID, plainText)
+	if err != nil {
+		respond(s, m, "Failed to save the guild settings.")
+		return
+	}
+	respond(s, m, "Changed the output format.")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func writeLegacyPermissions(t *testing.T) string {
+	t.Helper()
+	err := saveJSON(permissionsFile, Permissions{
+		Users: map[string]PermissionLevel{"1": PermissionModerator},
+		Roles: map[string]PermissionLevel{"2": PermissionAdmin},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(config.DataDir, permissionsFile)
+}
+
+func TestLoadGuildConfigsKeepsPermissionsWithoutDefaultGuild(t *testing.T) {
+	_, restore := withTestConfig(t, "")
+	defer restore()
+	filePath := writeLegacyPermissions(t)
+
+	g, err := LoadGuildConfigs("", config.Guilds.ServerList(""), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filePath); err != nil {
+		t.Fatalf("expected %s to be kept until a default guild is set: %v", permissionsFile, err)
+	}
+	if len(g.Guilds) != 0 {
+		t.Fatalf("expected no guild to receive the permissions, got %v", g.Guilds)
+	}
+
+	// the permissions are moved once the default guild is set
+	g, err = LoadGuildConfigs("5", config.Guilds.ServerList(""), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be removed after the migration", permissionsFile)
+	}
+	if level := g.Level("5", &discordgo.User{ID: "1"}, nil); level != PermissionModerator {
+		t.Errorf("expected the user to be a moderator in the default guild, got %s", level)
+	}
+	if level := g.Level("5", &discordgo.User{ID: "3"}, []string{"2"}); level != PermissionAdmin {
+		t.Errorf("expected the role to be admin in the default guild, got %s", level)
+	}
+	if level := g.Level("6", &discordgo.User{ID: "1"}, nil); level != PermissionNone {
+		t.Errorf("expected the user to have no permissions in other guilds, got %s", level)
+	}
+
+	// the migrated permissions are persisted
+	g, err = LoadGuildConfigs("5", config.Guilds.ServerList(""), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level := g.Level("5", &discordgo.User{ID: "1"}, nil); level != PermissionModerator {
+		t.Errorf("expected the migrated permissions to be saved, got %s", level)
+	}
+}
+
+func TestLoadGuildConfigsKeepsGuildPermissions(t *testing.T) {
+	_, restore := withTestConfig(t, "")
+	defer restore()
+
+	err := saveJSON(guildsFile, &GuildConfigs{Guilds: map[string]*GuildConfig{
+		"5": {Permissions: Permissions{Users: map[string]PermissionLevel{"4": PermissionAdmin}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeLegacyPermissions(t)
+
+	g, err := LoadGuildConfigs("5", config.Guilds.ServerList(""), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level := g.Level("5", &discordgo.User{ID: "4"}, nil); level != PermissionAdmin {
+		t.Errorf("expected the guild's own user permissions to be kept, got %s", level)
+	}
+	if level := g.Level("5", &discordgo.User{ID: "1"}, nil); level != PermissionNone {
+		t.Errorf("expected the legacy user permissions not to override the guild's, got %s", level)
+	}
+	if level := g.Level("5", &discordgo.User{ID: "3"}, []string{"2"}); level != PermissionAdmin {
+		t.Errorf("expected the legacy role permissions to be moved, got %s", level)
+	}
+}
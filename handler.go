@@ -1,10 +1,9 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
+	"log"
 	"net"
-	"os"
 	"sort"
 	"strconv"
 	"strings"
@@ -21,35 +20,87 @@ type MessageCreateHandler func(*discordgo.Session, *discordgo.MessageCreate, str
 // MessageCreateMiddleware is a wrapper fucntion
 type MessageCreateMiddleware func(MessageCreateHandler) MessageCreateHandler
 
-// HelpHandler shows the help message
-func HelpHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
-	sb := strings.Builder{}
-	sb.WriteString("Teeworlds Discord Bot by jxsl13. Have fun.\n")
-	sb.WriteString("Commands:\n")
+const (
+	// onlineExtendedFlag enables the output of scores and spectators in !online
+	onlineExtendedFlag = "-full"
 
-	if config.DefaultGameTypeFilter != "" {
-		filter := strings.ToLower(config.DefaultGameTypeFilter)
-		formated := fmt.Sprintf("	**!online [%s]**  - List all registered servers that have players playing(**!o [%s]**).\n", filter, filter)
-		sb.WriteString(formated)
-	} else {
-		sb.WriteString("	**!online [gametype]**  - List all registered servers that have players playing(**!o [gametype]**).\n")
-	}
-
-	sb.WriteString("	**!servers** - Show all servers that are currently registered(**!s**).\n")
-	s.ChannelMessageSend(m.ChannelID, sb.String())
-}
+	// flags of the player type in the server info
+	playerFlagSpectator = 1
+	playerFlagBot       = 2
+)
 
 // OnlineHandler handler the !online command
 func OnlineHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
-	gametype := strings.ToLower(strings.TrimSpace(args))
+	list := config.Guilds.ServerList(m.GuildID)
+	args, extended := parseOnlineArgs(args)
+	gametype, group := strings.ToLower(strings.TrimSpace(args)), ""
+
+	// groups take precedence over gametypes with the same name
+	if gametype != "" && list.HasGroup(gametype) {
+		gametype, group = "", gametype
+	}
 
 	// set default filter
-	if gametype == "" && config.DefaultGameTypeFilter != "" {
-		gametype = config.DefaultGameTypeFilter
+	if gametype == "" && group == "" {
+		gametype = config.Guilds.GameTypeFilter(m.GuildID)
+	}
+
+	infos, updatedAt := guildSnapshot(m.GuildID)
+	if updatedAt.IsZero() {
+		respond(s, m, errCacheEmpty)
+		return
+	}
+	infos = visibleServerInfos(list, infos, group)
+
+	if config.Guilds.PlainText(m.GuildID) || len(onlineServers(infos, gametype)) == 0 {
+		respondPaginated(s, m, textPages(onlineMessages(list, infos, updatedAt, gametype, extended)))
+		return
 	}
+	respondPaginated(s, m, embedMessages(onlineEmbeds(list, infos, updatedAt, gametype, extended)))
+}
+
+// parseOnlineArgs removes the -full flag that enables the extended output from the arguments
+func parseOnlineArgs(args string) (remaining string, extended bool) {
+	fields := strings.Fields(args)
+	filtered := fields[:0]
+	for _, field := range fields {
+		if strings.ToLower(field) == onlineExtendedFlag {
+			extended = true
+			continue
+		}
+		filtered = append(filtered, field)
+	}
+	return strings.Join(filtered, " "), extended
+}
 
-	infos := fetchServerInfos()
+// onlineMessages formats all servers that have players playing the given gametype into
+// messages that do not exceed the discord message size limit. Server aliases are taken from the list.
+// The extended output shows the scores of the players and lists the spectators separately.
+func onlineMessages(list *ConcurrentServerList, infos []browser.ServerInfo, updatedAt time.Time, gametype string, extended bool) (messages []string) {
+	filteredServers := onlineServers(infos, gametype)
+	if len(filteredServers) == 0 {
+		return []string{fmt.Sprintf("no online servers found, last updated %s.", formatAge(updatedAt))}
+	}
+
+	w := NewOutputWriter()
+	for _, server := range filteredServers {
+		w.WriteLine(fmt.Sprintf("**%s**%s - Map: **%s** (%d/%2d)", Escape(server.Name), aliasSuffix(list, server), Escape(server.Map), server.NumClients, server.MaxClients))
 
+		lines := compactPlayerLines(server.Players)
+		if extended {
+			lines = extendedPlayerLines(server)
+		}
+		for _, line := range lines {
+			w.WriteLine(line)
+		}
+	}
+	w.WriteLine(fmt.Sprintf("*last updated %s*", formatAge(updatedAt)))
+
+	return w.Messages()
+}
+
+// onlineServers returns the servers that have players playing the given gametype, most players first
+func onlineServers(infos []browser.ServerInfo, gametype string) []browser.ServerInfo {
 	filteredServers := make([]browser.ServerInfo, 0, len(infos))
 
 	for _, server := range infos {
@@ -63,52 +114,102 @@ func OnlineHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string
 		}
 	}
 
-	if len(filteredServers) == 0 {
-		s.ChannelMessageSend(m.ChannelID, "no online servers found.")
-		return
-	}
-
 	sort.Sort(byPlayerCountDescending(filteredServers))
+	return filteredServers
+}
 
-	sb := strings.Builder{}
-	sb.Grow(2000)
-
-	for _, server := range filteredServers {
-
-		sb.WriteString(fmt.Sprintf("**%s** - Map: **%s** (%d/%2d)\n", Escape(server.Name), Escape(server.Map), server.NumClients, server.MaxClients))
-
-		for _, player := range server.Players {
-			inlineCode := WrapInInlineCodeBlock(fmt.Sprintf("%-20s %-16s", player.Name, player.Clan))
-			sb.WriteString(fmt.Sprintf("%s %s \n", Flag(player.Country), inlineCode))
+// compactPlayerLines formats the name, the clan and the country of every player
+func compactPlayerLines(players []browser.PlayerInfo) []string {
+	lines := make([]string, 0, len(players))
+	for _, player := range players {
+		inlineCode := WrapInInlineCodeBlock(fmt.Sprintf("%-20s %-16s", player.Name, player.Clan))
+		lines = append(lines, fmt.Sprintf("%s %s \n", Flag(player.Country), inlineCode))
+	}
+	return lines
+}
 
-			if sb.Len() > 1800 {
-				s.ChannelMessageSend(m.ChannelID, sb.String())
-				sb.Reset()
-			}
+// extendedPlayerLines formats the players sorted by their score followed by the spectators.
+// The server info does not tell the team of a player, so the red and blue team cannot be told apart.
+func extendedPlayerLines(server browser.ServerInfo) []string {
+	players := make([]browser.PlayerInfo, 0, len(server.Players))
+	spectators := make([]browser.PlayerInfo, 0, len(server.Players))
+	for _, player := range server.Players {
+		if player.Type&playerFlagSpectator != 0 {
+			spectators = append(spectators, player)
+		} else {
+			players = append(players, player)
 		}
+	}
+	sort.Sort(byScoreDescending(players))
+	sort.Sort(byScoreDescending(spectators))
 
-		// only send if threshold exceeded to send less messages with more text
-		if sb.Len() > 1700 {
-			s.ChannelMessageSend(m.ChannelID, sb.String())
-			sb.Reset()
-		}
+	lines := make([]string, 0, len(server.Players)+2)
+	if len(players) > 0 {
+		lines = append(lines, fmt.Sprintf("*Players (%d)*\n", len(players)))
+	}
+	for _, player := range players {
+		lines = append(lines, extendedPlayerLine(player, true))
+	}
+	if len(spectators) > 0 {
+		lines = append(lines, fmt.Sprintf("*Spectators (%d)*\n", len(spectators)))
 	}
+	for _, player := range spectators {
+		lines = append(lines, extendedPlayerLine(player, false))
+	}
+	return lines
+}
 
-	// send remaining text
-	if sb.Len() > 0 {
-		s.ChannelMessageSend(m.ChannelID, sb.String())
+// extendedPlayerLine formats a single player, bots are marked as such
+func extendedPlayerLine(player browser.PlayerInfo, showScore bool) string {
+	score := ""
+	if showScore {
+		score = strconv.Itoa(player.Score)
 	}
+	marker := ""
+	if player.Type&playerFlagBot != 0 {
+		marker = "bot"
+	}
+	inlineCode := WrapInInlineCodeBlock(fmt.Sprintf("%6s %-20s %-16s %3s", score, player.Name, player.Clan, marker))
+	return fmt.Sprintf("%s %s \n", Flag(player.Country), inlineCode)
+}
 
+// aliasSuffix returns the alias of the server in parentheses, if it has one
+func aliasSuffix(list *ConcurrentServerList, server browser.ServerInfo) string {
+	entry, ok := list.Resolved(server.Address)
+	if !ok || entry.Alias == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (%s)", Escape(entry.Alias))
 }
 
 // ServersHandler handles the !servers command
 func ServersHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
-	infos := fetchServerInfos()
+	list := config.Guilds.ServerList(m.GuildID)
+	group := strings.ToLower(strings.TrimSpace(args))
+	if group != "" && !list.HasGroup(group) {
+		respond(s, m, fmt.Sprintf("unknown group: %s", Escape(group)))
+		return
+	}
 
-	sort.Sort(byPlayerCountDescending(infos))
+	infos, updatedAt := guildSnapshot(m.GuildID)
+	if updatedAt.IsZero() {
+		respond(s, m, errCacheEmpty)
+		return
+	}
 
-	sb := strings.Builder{}
-	sb.Grow(2000)
+	// moderators also see the hidden servers
+	showHidden := authorLevel(m) >= PermissionModerator
+	filtered := infos[:0]
+	for _, info := range infos {
+		entry, ok := list.Resolved(info.Address)
+		if !ok || (entry.Hidden && !showHidden) || (group != "" && !entry.InGroup(group)) {
+			continue
+		}
+		filtered = append(filtered, info)
+	}
+	infos = filtered
+
+	sort.Sort(byPlayerCountDescending(infos))
 
 	fetchedServers := 0
 	for _, server := range infos {
@@ -118,48 +219,79 @@ func ServersHandler(s *discordgo.Session, m *discordgo.MessageCreate, args strin
 	}
 
 	if fetchedServers == 0 {
-		s.ChannelMessageSend(m.ChannelID, "could not fetch any server infos.")
+		respond(s, m, fmt.Sprintf("could not fetch any server infos, last updated %s.", formatAge(updatedAt)))
+		return
+	}
+
+	if config.Guilds.PlainText(m.GuildID) {
+		respondPaginated(s, m, textPages(serversMessages(list, infos, updatedAt)))
 		return
 	}
 
+	title := "Servers"
+	if group != "" {
+		title = fmt.Sprintf("Servers (%s)", group)
+	}
+	respondPaginated(s, m, embedMessages(serversEmbeds(list, infos, updatedAt, title)))
+}
+
+// serversMessages formats the servers into messages that do not exceed the discord message size limit
+func serversMessages(list *ConcurrentServerList, infos []browser.ServerInfo, updatedAt time.Time) []string {
+	w := NewOutputWriter()
 	for _, server := range infos {
+		entry, _ := list.Resolved(server.Address)
+		address := Escape(list.DisplayAddress(server.Address))
 
+		line := ""
 		if server.Name == "" {
-			sb.WriteString(fmt.Sprintf("Failed to fetch: %s\n", server.Address))
+			line = fmt.Sprintf("Failed to fetch: %s%s", address, aliasSuffix(list, server))
 		} else {
 			playersFormat := fmt.Sprintf("(%d/%d)", server.NumClients, server.MaxClients)
-			lineFormat := fmt.Sprintf("**%s** Address: %s Map: **%s** %7s\n", Escape(server.Name), server.Address, Escape(server.Map), playersFormat)
-			sb.WriteString(lineFormat)
+			line = fmt.Sprintf("**%s**%s Address: %s Map: **%s** %7s", Escape(server.Name), aliasSuffix(list, server), address, Escape(server.Map), playersFormat)
 		}
-
-		if sb.Len() > 1000 {
-			s.ChannelMessageSend(m.ChannelID, sb.String())
-			sb.Reset()
+		if entry.Description != "" {
+			line += " - " + Escape(entry.Description)
+		}
+		if entry.Hidden {
+			line += " *(hidden)*"
 		}
+		w.WriteLine(line)
 	}
+	w.WriteLine(fmt.Sprintf("*last updated %s*", formatAge(updatedAt)))
 
-	if sb.Len() > 0 {
-		s.ChannelMessageSend(m.ChannelID, sb.String())
-	}
+	return w.Messages()
+}
+
+func fetchServerInfos(servers []*net.UDPAddr) []browser.ServerInfo {
+	infos, _ := fetchServerInfosWithLatencies(servers)
+	return infos
 }
 
-func fetchServerInfos() []browser.ServerInfo {
-	numServers := config.ServerList.Len()
+// fetchServerInfosWithLatencies also returns how long each reachable server took to respond
+func fetchServerInfosWithLatencies(servers []*net.UDPAddr) ([]browser.ServerInfo, map[string]time.Duration) {
+	numServers := len(servers)
 	cm := browser.NewConcurrentMap(numServers)
+	latencies := &latencyMap{latencies: make(map[string]time.Duration, numServers)}
 
 	wg := sync.WaitGroup{}
 	wg.Add(numServers)
 
-	for _, addr := range config.ServerList.List() {
-		go fetchServerInfoFromServerAddress(addr, config.ResponseTimeout, &cm, &wg)
+	for _, addr := range servers {
+		go fetchServerInfoFromServerAddress(addr, config.ResponseTimeout, &cm, latencies, &wg)
 	}
 
 	wg.Wait()
 
-	return cm.Values()
+	return cm.Values(), latencies.latencies
+}
+
+// latencyMap collects the response times of concurrently fetched servers
+type latencyMap struct {
+	sync.Mutex
+	latencies map[string]time.Duration
 }
 
-func fetchServerInfoFromServerAddress(srv *net.UDPAddr, timeout time.Duration, cm *browser.ConcurrentMap, wg *sync.WaitGroup) {
+func fetchServerInfoFromServerAddress(srv *net.UDPAddr, timeout time.Duration, cm *browser.ConcurrentMap, latencies *latencyMap, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	conn, err := net.DialUDP("udp", nil, srv)
@@ -173,12 +305,14 @@ func fetchServerInfoFromServerAddress(srv *net.UDPAddr, timeout time.Duration, c
 	conn.SetReadBuffer(maxBufferSize)
 	conn.SetWriteBuffer(int(maxBufferSize * timeout.Seconds()))
 
+	begin := time.Now()
 	resp, err := browser.Fetch("serverinfo", conn, timeout)
 	if err != nil {
 		// no server name -> failed to fetch
 		cm.Add(browser.ServerInfo{Address: srv.String(), Name: ""}, 0)
 		return
 	}
+	latency := time.Since(begin)
 
 	info, err := browser.ParseServerInfo(resp, srv.String())
 	if err != nil {
@@ -187,64 +321,55 @@ func fetchServerInfoFromServerAddress(srv *net.UDPAddr, timeout time.Duration, c
 		return
 	}
 	cm.Add(info, 0)
+
+	latencies.Lock()
+	latencies.latencies[info.Address] = latency
+	latencies.Unlock()
 }
 
 // AddHandler handles the !add command
 func AddHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
-	err := config.ServerList.Add(args)
+	err := config.Guilds.ServerList(m.GuildID).Add(args)
 
 	if err != nil {
-		s.ChannelMessageSend(m.ChannelID, err.Error())
+		respond(s, m, err.Error())
 		return
 	}
-	s.ChannelMessageSend(m.ChannelID, "Added.")
+	autosaveServerList(s, m)
+	respond(s, m, "Added.")
 }
 
 // SaveHandler handles the !add command
 func SaveHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
-
-	servers := config.ServerList.SortedList()
-	filePath := config.FilePath
-
-	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_TRUNC|os.O_CREATE, 0600)
+	err := saveServerFile(config.Guilds.ServerFile(m.GuildID), config.Guilds.ServerList(m.GuildID))
 	if err != nil {
-		s.ChannelMessageSend(m.ChannelID, "Failed to create file.")
+		log.Printf("failed to save the server list: %v\n", err)
+		respond(s, m, "Failed to write to file.")
 		return
 	}
-	defer file.Close()
 
-	sb := strings.Builder{}
-	sb.Grow(len(servers) * 26) // IP:port\n (max. 26 characters)
-
-	w := bufio.NewWriter(file)
-
-	for _, server := range servers {
-		w.WriteString(fmt.Sprintf("%s\n", server.String()))
-	}
-	err = w.Flush()
-	if err != nil {
-		s.ChannelMessageSend(m.ChannelID, "Failed to write to file.")
-		return
-	}
-
-	s.ChannelMessageSend(m.ChannelID, "Successfully saved to file.")
+	respond(s, m, "Successfully saved to file.")
 }
 
 // DeleteHandler handles the !add command
 func DeleteHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
-	err := config.ServerList.Delete(args)
+	err := config.Guilds.ServerList(m.GuildID).Delete(args)
 
 	if err != nil {
-		s.ChannelMessageSend(m.ChannelID, err.Error())
+		respond(s, m, err.Error())
 		return
 	}
-	s.ChannelMessageSend(m.ChannelID, "Deleted.")
+	autosaveServerList(s, m)
+	respond(s, m, "Deleted.")
 }
 
 // ClearHandler handles the !clear command that removes no accessible servers.
 func ClearHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
 
-	infos := fetchServerInfos()
+	list := config.Guilds.ServerList(m.GuildID)
+
+	// the cached snapshot counts as the first fetch attempt
+	infos, _ := guildSnapshot(m.GuildID)
 
 	serverMap := make(map[string]int, len(infos))
 
@@ -261,7 +386,7 @@ func ClearHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string)
 	}
 
 	for i := 0; i < retries; i++ {
-		infos := fetchServerInfos()
+		infos := fetchServerInfos(list.List())
 
 		for _, fetchedInfo := range infos {
 			if fetchedInfo.Name != "" {
@@ -270,36 +395,49 @@ func ClearHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string)
 		}
 	}
 
-	knownServers := config.ServerList.SortedList()
-
-	sb := strings.Builder{}
+	w := NewOutputWriter()
+	removed := 0
 
-	for _, knownServer := range knownServers {
-		address := knownServer.String()
-		if serverMap[address] == 0 {
-			config.ServerList.Delete(address)
-			sb.WriteString(fmt.Sprintf("removed: %s\n", address))
-
-			if sb.Len() > 1800 {
-				s.ChannelMessageSend(m.ChannelID, sb.String())
-				sb.Reset()
+	for _, entry := range list.Entries() {
+		reachable := false
+		for _, addr := range entry.Addrs {
+			if serverMap[addr.String()] > 0 {
+				reachable = true
 			}
 		}
+
+		if !reachable {
+			address := entry.String()
+			list.Delete(address)
+			w.WriteLine(fmt.Sprintf("removed: %s", address))
+			removed++
+		}
 	}
 
-	if sb.Len() > 0 {
-		s.ChannelMessageSend(m.ChannelID, sb.String())
-		sb.Reset()
+	if removed > 0 {
+		autosaveServerList(s, m)
+		w.Send(s, m)
 	}
 }
 
 // AdminMessageCreateMiddleware is a wrapper that wraps around specific handler functions in order to deny access to non-admin users.
 func AdminMessageCreateMiddleware(next MessageCreateHandler) MessageCreateHandler {
-	return func(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
-		if config.Admin == "" || m.Author.String() != config.Admin {
-			s.ChannelMessageSend(m.ChannelID, "you are not allowed to access this command.")
-			return
+	return permissionMessageCreateMiddleware(PermissionAdmin)(next)
+}
+
+// ModeratorMessageCreateMiddleware is a wrapper that denies access to users that are neither moderators nor admins.
+func ModeratorMessageCreateMiddleware(next MessageCreateHandler) MessageCreateHandler {
+	return permissionMessageCreateMiddleware(PermissionModerator)(next)
+}
+
+func permissionMessageCreateMiddleware(required PermissionLevel) MessageCreateMiddleware {
+	return func(next MessageCreateHandler) MessageCreateHandler {
+		return func(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
+			if authorLevel(m) < required {
+				respond(s, m, "you are not allowed to access this command.")
+				return
+			}
+			next(s, m, args)
 		}
-		next(s, m, args)
 	}
 }
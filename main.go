@@ -1,11 +1,8 @@
 package main
 
 import (
-	"bufio"
 	"flag"
-	"fmt"
 	"log"
-	"net"
 	"os"
 	"os/signal"
 	"regexp"
@@ -16,6 +13,7 @@ import (
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/joho/godotenv"
+	"github.com/jxsl13/twapi/browser"
 )
 
 const (
@@ -27,12 +25,9 @@ var (
 	extractIPRegex = regexp.MustCompile(`([a-fA-F:.0-9]{7,40}):(\d+)`)
 )
 
-type ipPort struct {
-	IP   string
-	Port string
-}
-
-func init() {
+// setup reads the configuration and the persisted state, it is not done in init,
+// so that tests of this package do not need a discord token or a server file.
+func setup() {
 	env, err := godotenv.Read(".env")
 	if err != nil {
 		log.Fatal(err)
@@ -50,7 +45,6 @@ func init() {
 		log.Fatal(err)
 	}
 
-	config.Admin = env["DISCORD_ADMIN"]
 	config.DefaultGameTypeFilter = strings.ToLower(strings.TrimSpace(env["DEFAULT_GAMETYPE_FILTER"]))
 
 	fileName := ""
@@ -63,72 +57,168 @@ func init() {
 		log.Fatal("")
 	}
 
-	file, err := os.Open(fileName)
+	config.FilePath = fileName
+
+	defaultServerList, err := loadServerFile(fileName)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer file.Close()
 
-	config.FilePath = fileName
+	responseTimeoutMsStr := env["SERVER_RESPONSE_TIMEOUT_MS"]
 
-	addressSet := make(map[ipPort]bool, 1)
-	sc := bufio.NewScanner(file)
-	for sc.Scan() {
-		line := strings.TrimSpace(sc.Text())
+	responseTimeoutMs, err := strconv.Atoi(responseTimeoutMsStr)
+	if err != nil || responseTimeoutMs < 5 {
+		responseTimeoutMs = 500
+	}
 
-		if strings.HasPrefix(line, "#") {
-			continue
-		}
-		matches := extractIPRegex.FindStringSubmatch(line)
-		if len(matches) != 3 {
-			log.Printf("'%s' invalid line format, skipping..\n", line)
-			continue
-		}
-		address := ipPort{matches[1], matches[2]}
-		addressSet[address] = true
+	config.ResponseTimeout = time.Millisecond * time.Duration(responseTimeoutMs)
+
+	pollIntervalMsStr := env["SERVER_POLL_INTERVAL_MS"]
+
+	pollIntervalMs, err := strconv.Atoi(pollIntervalMsStr)
+	if err != nil || pollIntervalMs < 1000 {
+		pollIntervalMs = 15000
 	}
 
-	config.ServerList = NewConcurrentServerList(len(addressSet))
-	for addr := range addressSet {
+	config.PollInterval = time.Millisecond * time.Duration(pollIntervalMs)
+	if config.PollInterval < config.ResponseTimeout {
+		config.PollInterval = config.ResponseTimeout
+	}
 
-		// validate IP
-		ip := net.ParseIP(addr.IP)
-		if ip == nil {
-			log.Printf("invalid IP '%s', with port '%s", ip, addr.Port)
-			continue
-		}
+	resolveIntervalMs, err := strconv.Atoi(env["DNS_RESOLVE_INTERVAL_MS"])
+	if err != nil || resolveIntervalMs < 1000 {
+		resolveIntervalMs = 600000
+	}
+
+	config.ResolveInterval = time.Millisecond * time.Duration(resolveIntervalMs)
 
-		// validate Port
-		port, err := strconv.Atoi(addr.Port)
-		if err != nil || port < 1024 {
-			log.Printf("invalid port '%d', with IP '%s", port, ip)
-			continue
+	// 0 disables watching the server files, they are still reloaded on SIGHUP
+	watchIntervalMs, err := strconv.Atoi(env["SERVER_FILE_WATCH_INTERVAL_MS"])
+	if err != nil || watchIntervalMs < 0 {
+		watchIntervalMs = 5000
+	}
+
+	config.WatchInterval = time.Millisecond * time.Duration(watchIntervalMs)
+
+	// the server lists are saved after every change
+	config.AutoSave, _ = strconv.ParseBool(env["SERVER_FILE_AUTOSAVE"])
+
+	backups, err := strconv.Atoi(env["SERVER_FILE_BACKUPS"])
+	if err != nil || backups < 0 {
+		backups = 3
+	}
+	config.ServerFileBackups = backups
+
+	// MASTER_SERVERS replaces the official master servers, e.g. with a local master server
+	masterServers := browser.MasterServerAddresses
+	if env["MASTER_SERVERS"] != "" {
+		masterServers, err = resolveMasterServers(env["MASTER_SERVERS"])
+		if err != nil {
+			log.Fatal(err)
 		}
+	}
+	config.Discoverer = &Discoverer{MasterServers: masterServers, Timeout: browser.TimeoutMasterServers}
 
-		// add server to list
-		config.ServerList.Add(fmt.Sprintf("%s:%d", ip, port))
+	// ECON_FILE contains the external console credentials of the servers, the econ commands are disabled without it
+	econFile := strings.TrimSpace(env["ECON_FILE"])
+	if econFile == "" {
+		econFile = "econ.json"
 	}
+	config.Econ, err = LoadEconConfig(econFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	config.ChatBridges = NewChatBridges(config.Econ)
 
-	responseTimeoutMsStr := env["SERVER_RESPONSE_TIMEOUT_MS"]
+	config.DataDir = strings.TrimSpace(env["DATA_DIR"])
+	if config.DataDir == "" {
+		config.DataDir = "data"
+	}
 
-	responseTimeoutMs, err := strconv.Atoi(responseTimeoutMsStr)
-	if err != nil || responseTimeoutMs < 5 {
-		responseTimeoutMs = 500
+	// the servers of the -f file belong to the default guild, all guilds share them if no default guild is set.
+	defaultGuildID := strings.TrimSpace(env["DEFAULT_GUILD_ID"])
+	if defaultGuildID == "" {
+		log.Println("no DEFAULT_GUILD_ID specified, all guilds share the same server list")
 	}
 
-	config.ResponseTimeout = time.Millisecond * time.Duration(responseTimeoutMs)
+	// DISCORD_ADMIN contains a comma separated list of user IDs that always have admin permissions
+	config.Guilds, err = LoadGuildConfigs(defaultGuildID, defaultServerList, strings.Split(env["DISCORD_ADMIN"], ","))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	config.StatusBoards, err = LoadStatusBoards()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	config.PlayerNotifiers, err = LoadPlayerNotifiers()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	config.Watchlist, err = LoadWatchlist()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	config.DowntimeAlerts, err = LoadDowntimeAlerts()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	config.Uptime, err = LoadUptimeTracker()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	config.PlayerCounts = NewPlayerCountHistory()
+
+	config.Playtime, err = LoadPlaytimeTracker()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	config.ServerInfos.Subscribe(func(infos []browser.ServerInfo, updatedAt time.Time) {
+		config.StatusBoards.Refresh(config.DiscordSession, infos, updatedAt)
+	})
+	config.ServerInfos.Subscribe(func(infos []browser.ServerInfo, updatedAt time.Time) {
+		config.PlayerNotifiers.Notify(config.DiscordSession, infos, updatedAt)
+	})
+	config.ServerInfos.Subscribe(func(infos []browser.ServerInfo, updatedAt time.Time) {
+		config.Watchlist.Alert(config.DiscordSession, infos, updatedAt)
+	})
+	config.ServerInfos.Subscribe(func(infos []browser.ServerInfo, updatedAt time.Time) {
+		config.DowntimeAlerts.Alert(config.DiscordSession, infos, updatedAt)
+	})
+	config.ServerInfos.Subscribe(func(infos []browser.ServerInfo, updatedAt time.Time) {
+		config.PlayerCounts.Record(infos, updatedAt)
+	})
+	config.ServerInfos.Subscribe(func(infos []browser.ServerInfo, updatedAt time.Time) {
+		config.Playtime.Record(infos, updatedAt)
+	})
+
+	config.Commands = NewCommandRouter()
+	err = registerCommands(config.Commands)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	config.DiscordSession.AddHandler(DiscordMessageCreateHandler)
+	config.DiscordSession.AddHandler(ChatBridgeMessageCreateHandler)
+	config.DiscordSession.AddHandler(DiscordReadyHandler)
+	config.DiscordSession.AddHandler(DiscordInteractionCreateHandler)
 
 }
 
 // DiscordMessageLineCreateHandler checks every line for commands
 func DiscordMessageLineCreateHandler(s *discordgo.Session, m *discordgo.MessageCreate, line string) {
-	if !strings.HasPrefix(line, "!") {
+	line, ok := stripCommandPrefix(s, m, line)
+	if !ok {
 		return
 	}
 
-	ss := strings.SplitN(line[1:], " ", 2)
+	ss := strings.SplitN(line, " ", 2)
 	if len(ss) == 0 {
 		return
 	}
@@ -140,24 +230,11 @@ func DiscordMessageLineCreateHandler(s *discordgo.Session, m *discordgo.MessageC
 		arguments = strings.TrimSpace(ss[1])
 	}
 
-	switch command {
-	case "h", "help":
-		HelpHandler(s, m, arguments)
-	case "o", "online":
-		OnlineHandler(s, m, arguments)
-	case "s", "servers":
-		ServersHandler(s, m, arguments)
-	case "add":
-		AdminMessageCreateMiddleware(AddHandler)(s, m, arguments)
-	case "save":
-		AdminMessageCreateMiddleware(SaveHandler)(s, m, arguments)
-	case "delete":
-		AdminMessageCreateMiddleware(DeleteHandler)(s, m, arguments)
-	case "c", "clean", "clear":
-		AdminMessageCreateMiddleware(ClearHandler)(s, m, arguments)
-	default:
+	c, ok := config.Commands.Lookup(command)
+	if !ok {
 		return
 	}
+	c.Handle(s, m, arguments)
 }
 
 // DiscordMessageCreateHandler handles server messages sent by users.
@@ -173,8 +250,8 @@ func DiscordMessageCreateHandler(s *discordgo.Session, m *discordgo.MessageCreat
 	for _, line := range lines {
 		DiscordMessageLineCreateHandler(s, m, line)
 
-		// only the admin is allowed to execute multiple commands at once.
-		if m.Author.String() != config.Admin {
+		// only admins are allowed to execute multiple commands at once.
+		if authorLevel(m) < PermissionAdmin {
 			break
 		}
 	}
@@ -182,6 +259,7 @@ func DiscordMessageCreateHandler(s *discordgo.Session, m *discordgo.MessageCreat
 }
 
 func main() {
+	setup()
 
 	err := config.Open()
 	if err != nil {
@@ -189,10 +267,32 @@ func main() {
 	}
 	defer config.Close()
 
+	stopPolling := make(chan struct{})
+	defer close(stopPolling)
+	go pollServerInfos(config.PollInterval, stopPolling)
+	go resolveServerLists(config.ResolveInterval, stopPolling)
+	go watchServerFiles(config.DiscordSession, NewServerFileWatcher(), config.WatchInterval, stopPolling)
+	config.ChatBridges.Run(config.DiscordSession, stopPolling)
+
 	// Wait here until CTRL-C or other term signal is received.
 	log.Println("Bot is now running.  Press CTRL-C to exit.")
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM)
 	<-sc
 	log.Println("Shutting down, please wait...")
+
+	err = config.Uptime.Flush()
+	if err != nil {
+		log.Printf("failed to save the uptime history: %v\n", err)
+	}
+
+	err = config.PlayerCounts.Flush()
+	if err != nil {
+		log.Printf("failed to save the player counts: %v\n", err)
+	}
+
+	err = config.Playtime.Flush()
+	if err != nil {
+		log.Printf("failed to save the playtime: %v\n", err)
+	}
 }
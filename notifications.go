@@ -0,0 +1,329 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jxsl13/twapi/browser"
+)
+
+const (
+	notificationsFile = "notifications.json"
+
+	// at most notifyEventsPerWindow join/leave lines are posted per server within notifyWindow,
+	// everything above that is collapsed into a summary line.
+	notifyEventsPerWindow = 8
+	notifyWindow          = time.Minute
+)
+
+// LoadPlayerNotifiers reads the join/leave notification settings from the data directory
+func LoadPlayerNotifiers() (*PlayerNotifiers, error) {
+	notifiers := &PlayerNotifiers{Guilds: make(map[string]*PlayerNotifier)}
+	err := loadJSON(notificationsFile, notifiers)
+	if err != nil {
+		return nil, err
+	}
+	if notifiers.Guilds == nil {
+		notifiers.Guilds = make(map[string]*PlayerNotifier)
+	}
+
+	// notifications used to be configured for a single channel
+	if notifiers.ChannelID != "" || len(notifiers.Servers) > 0 {
+		notifiers.Guilds[config.Guilds.key("")] = &PlayerNotifier{ChannelID: notifiers.ChannelID, Servers: notifiers.Servers}
+		notifiers.ChannelID = ""
+		notifiers.Servers = nil
+		err = notifiers.save()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, n := range notifiers.Guilds {
+		n.reset()
+	}
+	return notifiers, nil
+}
+
+// PlayerNotifiers maps guild IDs to their join/leave notification settings
+type PlayerNotifiers struct {
+	sync.Mutex
+	Guilds map[string]*PlayerNotifier `json:"guilds"`
+
+	// legacy single notification channel
+	ChannelID string   `json:"channel_id,omitempty"`
+	Servers   []string `json:"servers,omitempty"`
+}
+
+// save must be called while holding the lock
+func (pn *PlayerNotifiers) save() error {
+	return saveJSON(notificationsFile, pn)
+}
+
+// get must be called while holding the lock, it creates missing notifiers
+func (pn *PlayerNotifiers) get(guildID string) *PlayerNotifier {
+	n, ok := pn.Guilds[guildID]
+	if !ok {
+		n = &PlayerNotifier{}
+		n.reset()
+		pn.Guilds[guildID] = n
+	}
+	return n
+}
+
+// Notify posts the join and leave events of all guilds
+func (pn *PlayerNotifiers) Notify(s *discordgo.Session, infos []browser.ServerInfo, updatedAt time.Time) {
+	pn.Lock()
+	defer pn.Unlock()
+
+	for guildID, n := range pn.Guilds {
+		n.Notify(s, infos, updatedAt, config.Guilds.ServerList(guildID))
+	}
+}
+
+// notifierServerState is what a server looked like in the previous poll
+type notifierServerState struct {
+	Map     string
+	Players map[string]int
+}
+
+// PlayerNotifier posts join and leave events of opted-in servers into a channel
+type PlayerNotifier struct {
+	ChannelID string   `json:"channel_id"`
+	Servers   []string `json:"servers"`
+
+	previous map[string]notifierServerState
+	sent     map[string][]time.Time
+}
+
+// reset forgets the previous poll and the sent events
+func (n *PlayerNotifier) reset() {
+	n.previous = make(map[string]notifierServerState)
+	n.sent = make(map[string][]time.Time)
+}
+
+func (n *PlayerNotifier) optedIn(address string) bool {
+	for _, s := range n.Servers {
+		if s == address {
+			return true
+		}
+	}
+	return false
+}
+
+// Notify diffs the players of the opted-in servers against the previous poll and posts the changes.
+// Servers that did not respond, came back online or changed their map are not diffed, but only
+// remembered, in order not to spam the channel with players that reconnect.
+// The guild's server list maps resolved addresses back to the registered hostnames.
+func (n *PlayerNotifier) Notify(s *discordgo.Session, infos []browser.ServerInfo, updatedAt time.Time, list *ConcurrentServerList) {
+	if n.ChannelID == "" {
+		return
+	}
+
+	sorted := make([]browser.ServerInfo, len(infos))
+	copy(sorted, infos)
+	sort.Sort(byServerAddress(sorted))
+
+	sb := strings.Builder{}
+
+	for _, server := range sorted {
+		if !n.optedIn(list.DisplayAddress(server.Address)) {
+			continue
+		}
+
+		if server.Name == "" {
+			// unreachable, the next successful poll only creates a new baseline
+			delete(n.previous, server.Address)
+			continue
+		}
+
+		current := notifierServerState{Map: server.Map, Players: make(map[string]int, len(server.Players))}
+		for _, player := range server.Players {
+			current.Players[player.Name]++
+		}
+
+		previous, ok := n.previous[server.Address]
+		n.previous[server.Address] = current
+
+		if !ok || previous.Map != current.Map {
+			continue
+		}
+
+		joined, left := diffPlayers(previous.Players, current.Players)
+		sb.WriteString(n.formatEvents(server, joined, left, updatedAt))
+	}
+
+	if sb.Len() == 0 {
+		return
+	}
+
+	w := NewOutputWriter()
+	w.Write(sb.String())
+	for _, msg := range w.Messages() {
+		_, err := s.ChannelMessageSend(n.ChannelID, msg)
+		if err != nil {
+			log.Printf("failed to send join/leave notification: %v\n", err)
+		}
+	}
+}
+
+func (n *PlayerNotifier) formatEvents(server browser.ServerInfo, joined, left []string, now time.Time) string {
+	numEvents := len(joined) + len(left)
+	if numEvents == 0 {
+		return ""
+	}
+
+	// drop timestamps that left the rate limiting window
+	sent := n.sent[server.Address][:0]
+	for _, t := range n.sent[server.Address] {
+		if now.Sub(t) < notifyWindow {
+			sent = append(sent, t)
+		}
+	}
+
+	budget := notifyEventsPerWindow - len(sent)
+	serverName := Escape(server.Name)
+	sb := strings.Builder{}
+
+	switch {
+	case budget <= 0:
+		// rate limited, drop the events
+	case numEvents > budget:
+		sb.WriteString(fmt.Sprintf("%d players joined and %d players left **%s**\n", len(joined), len(left), serverName))
+		sent = append(sent, now)
+	default:
+		for _, name := range joined {
+			sb.WriteString(fmt.Sprintf("%s joined **%s**\n", WrapInInlineCodeBlock(name), serverName))
+			sent = append(sent, now)
+		}
+		for _, name := range left {
+			sb.WriteString(fmt.Sprintf("%s left **%s**\n", WrapInInlineCodeBlock(name), serverName))
+			sent = append(sent, now)
+		}
+	}
+
+	n.sent[server.Address] = sent
+	return sb.String()
+}
+
+// diffPlayers compares two sets of player names with their number of occurrences
+func diffPlayers(previous, current map[string]int) (joined, left []string) {
+	for name, cnt := range current {
+		for i := previous[name]; i < cnt; i++ {
+			joined = append(joined, name)
+		}
+	}
+	for name, cnt := range previous {
+		for i := current[name]; i < cnt; i++ {
+			left = append(left, name)
+		}
+	}
+	sort.Strings(joined)
+	sort.Strings(left)
+	return
+}
+
+// NotifyHandler handles the !notify command that configures join/leave notifications.
+func NotifyHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
+	ss := strings.SplitN(strings.TrimSpace(args), " ", 2)
+	subcommand := strings.ToLower(ss[0])
+	argument := ""
+	if len(ss) > 1 {
+		argument = strings.TrimSpace(ss[1])
+	}
+
+	pn := config.PlayerNotifiers
+	pn.Lock()
+	defer pn.Unlock()
+
+	n := pn.get(m.GuildID)
+
+	reply := ""
+	switch subcommand {
+	case "":
+		if n.ChannelID == "" {
+			reply = "Join/leave notifications are disabled."
+		} else {
+			reply = fmt.Sprintf("Join/leave notifications are posted to <#%s>.", n.ChannelID)
+		}
+		if len(n.Servers) == 0 {
+			reply += "\nNo servers opted in."
+		} else {
+			reply += fmt.Sprintf("\nOpted in servers: %s", strings.Join(n.Servers, ", "))
+		}
+		respond(s, m, reply)
+		return
+	case "channel":
+		channelID, err := mentionedChannel(s, m, argument)
+		if err != nil {
+			respond(s, m, err.Error())
+			return
+		}
+		n.ChannelID = channelID
+		if channelID == "" {
+			reply = "Join/leave notifications disabled."
+		} else {
+			reply = fmt.Sprintf("Join/leave notifications are posted to <#%s>.", n.ChannelID)
+		}
+	case "add":
+		_, err := parseAddress(argument)
+		if err != nil {
+			respond(s, m, err.Error())
+			return
+		}
+		entry, ok := config.Guilds.ServerList(m.GuildID).Lookup(argument)
+		if !ok {
+			respond(s, m, "server is not registered.")
+			return
+		}
+		address := entry.String()
+		if n.optedIn(address) {
+			respond(s, m, "server already opted in.")
+			return
+		}
+		n.Servers = append(n.Servers, address)
+		sort.Strings(n.Servers)
+		reply = "Opted in."
+	case "remove":
+		parsed, err := parseAddress(argument)
+		if err != nil {
+			respond(s, m, err.Error())
+			return
+		}
+		address := parsed.String()
+		entry, ok := config.Guilds.ServerList(m.GuildID).Lookup(argument)
+		if ok {
+			address = entry.String()
+		}
+		servers := n.Servers[:0]
+		for _, server := range n.Servers {
+			if server != address {
+				servers = append(servers, server)
+			}
+		}
+		if len(servers) == len(n.Servers) {
+			respond(s, m, "server is not opted in.")
+			return
+		}
+		n.Servers = servers
+		delete(n.previous, address)
+		for _, addr := range entry.Addrs {
+			delete(n.previous, addr.String())
+		}
+		reply = "Opted out."
+	default:
+		respond(s, m, usage(m, "notify"))
+		return
+	}
+
+	err := pn.save()
+	if err != nil {
+		respond(s, m, "Failed to save notification settings.")
+		return
+	}
+	respond(s, m, reply)
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// maxMessageLength is the number of characters discord allows per message
+	maxMessageLength = 2000
+
+	// outputs that need more messages are sent as file attachment by Send
+	maxOutputMessages = 5
+
+	codeFence = "```"
+	// room for closing the open code block and the emphasis markers at the end of a message
+	outputReserve = len("\n"+codeFence) + len("***~~")
+)
+
+var (
+	// emphasis markers that are balanced when a line is split, longer markers first
+	emphasisMarkers = []string{"**", "~~", "*"}
+)
+
+// OutputWriter accumulates lines of markdown text and splits them into messages that do not exceed the discord
+// message limit. Messages are only split between lines, lines that are too long are split between words and inline
+// code blocks. Code blocks and emphasis that span several messages are closed and opened again.
+type OutputWriter struct {
+	limit    int
+	messages []string
+	current  strings.Builder
+	// the opening line of the code block that is currently open, empty if there is none
+	fence string
+	// all lines for the file attachment
+	raw strings.Builder
+	// files that are attached to the first message
+	files []*discordgo.File
+}
+
+// NewOutputWriter creates a writer that splits the text at the discord message limit
+func NewOutputWriter() *OutputWriter {
+	return &OutputWriter{limit: maxMessageLength}
+}
+
+// Write adds the text, which may contain several lines
+func (w *OutputWriter) Write(text string) {
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		w.WriteLine(line)
+	}
+}
+
+// WriteLine adds a single line, a trailing line break is removed
+func (w *OutputWriter) WriteLine(line string) {
+	line = strings.TrimSuffix(line, "\n")
+	w.raw.WriteString(line + "\n")
+
+	for _, piece := range w.splitLine(line) {
+		if w.current.Len() > 0 && w.length()+1+utf8.RuneCountInString(piece)+outputReserve > w.limit {
+			w.flush()
+		}
+		if w.current.Len() > 0 {
+			w.current.WriteString("\n")
+		}
+		w.current.WriteString(piece)
+
+		if strings.HasPrefix(strings.TrimSpace(piece), codeFence) {
+			if w.fence == "" {
+				w.fence = strings.TrimSpace(piece)
+			} else {
+				w.fence = ""
+			}
+		}
+	}
+}
+
+// Attach adds files that Send attaches to the first message
+func (w *OutputWriter) Attach(files ...*discordgo.File) {
+	w.files = append(w.files, files...)
+}
+
+// Messages returns the messages that contain the whole text
+func (w *OutputWriter) Messages() []string {
+	w.flush()
+	return w.messages
+}
+
+// Send responds with the messages. If the text needs more than maxOutputMessages messages,
+// it is sent as file attachment instead.
+func (w *OutputWriter) Send(s *discordgo.Session, m *discordgo.MessageCreate) {
+	messages := w.Messages()
+	if len(messages) > maxOutputMessages {
+		respondComplex(s, m, &discordgo.MessageSend{
+			Content: "The output is too long, it is attached as file.",
+			Files: append([]*discordgo.File{
+				{Name: "output.md", ContentType: "text/markdown", Reader: bytes.NewReader([]byte(w.raw.String()))},
+			}, w.files...),
+		})
+		return
+	}
+
+	for idx, msg := range messages {
+		send := &discordgo.MessageSend{Content: msg}
+		if idx == 0 {
+			send.Files = w.files
+		}
+		respondComplex(s, m, send)
+	}
+}
+
+func (w *OutputWriter) length() int {
+	return utf8.RuneCountInString(w.current.String())
+}
+
+// flush finishes the current message, an open code block is continued in the next message
+func (w *OutputWriter) flush() {
+	if w.current.Len() == 0 {
+		return
+	}
+	if w.fence != "" {
+		w.current.WriteString("\n" + codeFence)
+	}
+	w.messages = append(w.messages, w.current.String())
+	w.current.Reset()
+
+	if w.fence != "" {
+		w.current.WriteString(w.fence)
+	}
+}
+
+// splitLine splits a line that does not fit into a single message into pieces that do
+func (w *OutputWriter) splitLine(line string) []string {
+	max := w.limit - utf8.RuneCountInString(w.fence) - 1 - outputReserve
+	if utf8.RuneCountInString(line) <= max {
+		return []string{line}
+	}
+
+	// there is no markdown within code blocks
+	if w.fence != "" {
+		return splitRunes(line, max)
+	}
+
+	pieces := make([]string, 0, 2)
+	piece := strings.Builder{}
+	// emphasis that is open at the current position
+	open := make([]string, 0, len(emphasisMarkers))
+
+	for _, atom := range markdownAtoms(line, max) {
+		if piece.Len() > 0 && utf8.RuneCountInString(piece.String())+utf8.RuneCountInString(atom) > max {
+			pieces = append(pieces, piece.String()+closeEmphasis(open))
+			piece.Reset()
+			piece.WriteString(strings.Join(open, ""))
+		}
+		piece.WriteString(atom)
+		if !strings.HasPrefix(atom, "`") {
+			open = toggleEmphasis(open, atom)
+		}
+	}
+	if piece.Len() > 0 {
+		pieces = append(pieces, piece.String())
+	}
+	return pieces
+}
+
+// markdownAtoms splits the line into inline code blocks and words including their trailing whitespace.
+// Atoms that are longer than max are split further, inline code blocks are split into several code blocks.
+func markdownAtoms(line string, max int) []string {
+	atoms := make([]string, 0, 16)
+	word := strings.Builder{}
+	endWord := func() {
+		if word.Len() > 0 {
+			atoms = append(atoms, splitRunes(word.String(), max)...)
+			word.Reset()
+		}
+	}
+
+	for idx := 0; idx < len(line); {
+		switch {
+		case line[idx] == '\\' && idx+1 < len(line):
+			_, size := utf8.DecodeRuneInString(line[idx+1:])
+			word.WriteString(line[idx : idx+1+size])
+			idx += 1 + size
+		case line[idx] == '`':
+			ticks := idx
+			for ticks < len(line) && line[ticks] == '`' {
+				ticks++
+			}
+			fence := line[idx:ticks]
+			end := closingBackticks(line, ticks, fence)
+			if end < 0 {
+				// not an inline code block
+				word.WriteString(fence)
+				idx = ticks
+				continue
+			}
+			endWord()
+			atoms = append(atoms, splitInlineCode(line[idx:end+len(fence)], fence, max)...)
+			idx = end + len(fence)
+		default:
+			r, size := utf8.DecodeRuneInString(line[idx:])
+			word.WriteRune(r)
+			idx += size
+			if unicode.IsSpace(r) {
+				endWord()
+			}
+		}
+	}
+	endWord()
+	return atoms
+}
+
+// closingBackticks returns the index of the backticks that close the inline code block
+// that was opened with the fence, -1 if it is not closed
+func closingBackticks(line string, from int, fence string) int {
+	for idx := from; idx < len(line); {
+		end := strings.Index(line[idx:], fence)
+		if end < 0 {
+			return -1
+		}
+		start := idx + end
+		stop := start + len(fence)
+		// a longer run of backticks does not close the block
+		if stop < len(line) && line[stop] == '`' {
+			for stop < len(line) && line[stop] == '`' {
+				stop++
+			}
+			idx = stop
+			continue
+		}
+		return start
+	}
+	return -1
+}
+
+// splitInlineCode splits an inline code block that is longer than max into several blocks
+func splitInlineCode(code, fence string, max int) []string {
+	if utf8.RuneCountInString(code) <= max {
+		return []string{code}
+	}
+
+	content := code[len(fence) : len(code)-len(fence)]
+	pieces := splitRunes(content, max-2*len(fence))
+	for idx, piece := range pieces {
+		pieces[idx] = fence + piece + fence
+	}
+	return pieces
+}
+
+// splitRunes splits the text into pieces of at most max characters
+func splitRunes(text string, max int) []string {
+	if max < 1 {
+		max = 1
+	}
+	runes := []rune(text)
+	pieces := make([]string, 0, len(runes)/max+1)
+	for len(runes) > max {
+		pieces = append(pieces, string(runes[:max]))
+		runes = runes[max:]
+	}
+	return append(pieces, string(runes))
+}
+
+// toggleEmphasis opens or closes the emphasis markers that occur unescaped in the text
+func toggleEmphasis(open []string, text string) []string {
+	for idx := 0; idx < len(text); {
+		if text[idx] == '\\' {
+			idx += 2
+			continue
+		}
+
+		matched := false
+		for _, marker := range emphasisMarkers {
+			if !strings.HasPrefix(text[idx:], marker) {
+				continue
+			}
+			if len(open) > 0 && open[len(open)-1] == marker {
+				open = open[:len(open)-1]
+			} else {
+				open = append(open, marker)
+			}
+			idx += len(marker)
+			matched = true
+			break
+		}
+		if !matched {
+			idx++
+		}
+	}
+	return open
+}
+
+// closeEmphasis returns the markers that close the open emphasis in reverse order
+func closeEmphasis(open []string) string {
+	sb := strings.Builder{}
+	for idx := len(open) - 1; idx >= 0; idx-- {
+		sb.WriteString(open[idx])
+	}
+	return sb.String()
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func assertMessageLengths(t *testing.T, messages []string) {
+	t.Helper()
+	for idx, msg := range messages {
+		if length := utf8.RuneCountInString(msg); length > maxMessageLength {
+			t.Errorf("message %d has %d characters", idx, length)
+		}
+		if strings.TrimSpace(msg) == "" {
+			t.Errorf("message %d is empty", idx)
+		}
+	}
+}
+
+// unescapedCount counts the occurrences of the marker that are not escaped with a backslash
+func unescapedCount(text, marker string) int {
+	count := 0
+	for idx := 0; idx < len(text); {
+		switch {
+		case text[idx] == '\\':
+			idx += 2
+		case strings.HasPrefix(text[idx:], marker):
+			count++
+			idx += len(marker)
+		default:
+			idx++
+		}
+	}
+	return count
+}
+
+func TestOutputWriterSingleMessage(t *testing.T) {
+	w := NewOutputWriter()
+	w.WriteLine("first")
+	w.Write("second\nthird\n")
+
+	messages := w.Messages()
+	if len(messages) != 1 || messages[0] != "first\nsecond\nthird" {
+		t.Fatalf("expected a single message, got %q", messages)
+	}
+
+	if messages := NewOutputWriter().Messages(); len(messages) != 0 {
+		t.Fatalf("expected no messages without text, got %q", messages)
+	}
+}
+
+func TestOutputWriterSplitsBetweenLines(t *testing.T) {
+	w := NewOutputWriter()
+	lines := make([]string, 0, 100)
+	for idx := 0; idx < 100; idx++ {
+		line := fmt.Sprintf("%03d %s", idx, strings.Repeat("x", 46))
+		lines = append(lines, line)
+		w.WriteLine(line)
+	}
+
+	messages := w.Messages()
+	assertMessageLengths(t, messages)
+	if len(messages) != 3 {
+		t.Fatalf("expected 5000 characters to be split into 3 messages, got %d", len(messages))
+	}
+	if joined := strings.Join(messages, "\n"); joined != strings.Join(lines, "\n") {
+		t.Fatal("expected the lines to be kept intact and in order")
+	}
+}
+
+func TestOutputWriterContinuesCodeBlocks(t *testing.T) {
+	w := NewOutputWriter()
+	w.WriteLine("output:")
+	w.WriteLine("```go")
+	for idx := 0; idx < 200; idx++ {
+		w.WriteLine(fmt.Sprintf("line %03d %s", idx, strings.Repeat("y", 20)))
+	}
+	w.WriteLine("```")
+	w.WriteLine("done")
+
+	messages := w.Messages()
+	assertMessageLengths(t, messages)
+	if len(messages) < 2 {
+		t.Fatalf("expected several messages, got %d", len(messages))
+	}
+
+	for idx, msg := range messages {
+		if count := strings.Count(msg, codeFence); count%2 != 0 {
+			t.Errorf("message %d has an unclosed code block", idx)
+		}
+		if idx > 0 && !strings.HasPrefix(msg, "```go\n") {
+			t.Errorf("expected message %d to open the code block with its language again, got %q", idx, msg[:10])
+		}
+	}
+	if last := messages[len(messages)-1]; !strings.HasSuffix(last, "```\ndone") {
+		t.Errorf("expected the code block to end before the last line, got %q", last[len(last)-20:])
+	}
+}
+
+func TestOutputWriterSplitsLongLines(t *testing.T) {
+	words := make([]string, 0, 800)
+	for idx := 0; idx < 800; idx++ {
+		words = append(words, fmt.Sprintf("word%03d", idx))
+	}
+	line := strings.Join(words, " ")
+
+	w := NewOutputWriter()
+	w.WriteLine(line)
+	messages := w.Messages()
+	assertMessageLengths(t, messages)
+	if len(messages) != 4 {
+		t.Fatalf("expected a line of %d characters to be split into 4 messages, got %d", len(line), len(messages))
+	}
+
+	// words are not split
+	rejoined := strings.Join(strings.Fields(strings.Join(messages, " ")), " ")
+	if rejoined != line {
+		t.Fatal("expected the line to be split between words")
+	}
+}
+
+func TestOutputWriterSplitsLongWords(t *testing.T) {
+	word := strings.Repeat("z", 4500)
+
+	w := NewOutputWriter()
+	w.WriteLine(word)
+	messages := w.Messages()
+	assertMessageLengths(t, messages)
+	if strings.Join(messages, "") != word {
+		t.Fatal("expected a word without spaces to be split anywhere")
+	}
+}
+
+func TestOutputWriterBalancesEmphasis(t *testing.T) {
+	line := "**" + strings.Repeat("bold ", 300) + "** *" + strings.Repeat("italic ", 300) + "* ~~" + strings.Repeat("strike ", 300) + "~~"
+
+	w := NewOutputWriter()
+	w.WriteLine(line)
+	messages := w.Messages()
+	assertMessageLengths(t, messages)
+	if len(messages) < 3 {
+		t.Fatalf("expected the line to be split into several messages, got %d", len(messages))
+	}
+
+	for idx, msg := range messages {
+		if count := unescapedCount(msg, "**"); count%2 != 0 {
+			t.Errorf("message %d has unbalanced bold markers: %d", idx, count)
+		}
+		if count := unescapedCount(msg, "~~"); count%2 != 0 {
+			t.Errorf("message %d has unbalanced strikethrough markers: %d", idx, count)
+		}
+		if count := unescapedCount(strings.Replace(msg, "**", "", -1), "*"); count%2 != 0 {
+			t.Errorf("message %d has unbalanced italic markers: %d", idx, count)
+		}
+	}
+
+	if !strings.HasPrefix(messages[1], "**") && !strings.HasPrefix(messages[1], "*") && !strings.HasPrefix(messages[1], "~~") {
+		t.Errorf("expected the emphasis to be opened again in the next message, got %q", messages[1][:10])
+	}
+}
+
+func TestOutputWriterIgnoresEscapedEmphasis(t *testing.T) {
+	line := `\*` + strings.Repeat("plain ", 500)
+
+	w := NewOutputWriter()
+	w.WriteLine(line)
+	messages := w.Messages()
+	assertMessageLengths(t, messages)
+	for idx, msg := range messages[1:] {
+		if strings.HasPrefix(msg, "*") {
+			t.Errorf("expected escaped markers not to be continued in message %d", idx+1)
+		}
+	}
+}
+
+func TestOutputWriterKeepsInlineCode(t *testing.T) {
+	code := "`" + strings.Repeat("c ", 20) + "`"
+	parts := make([]string, 0, 100)
+	for idx := 0; idx < 100; idx++ {
+		parts = append(parts, code)
+	}
+	line := strings.Join(parts, " ")
+
+	w := NewOutputWriter()
+	w.WriteLine(line)
+	messages := w.Messages()
+	assertMessageLengths(t, messages)
+	if len(messages) < 2 {
+		t.Fatalf("expected several messages, got %d", len(messages))
+	}
+	for idx, msg := range messages {
+		if count := strings.Count(msg, "`"); count%2 != 0 {
+			t.Errorf("message %d splits an inline code block", idx)
+		}
+	}
+}
+
+func TestOutputWriterSplitsLongInlineCode(t *testing.T) {
+	line := "``" + strings.Repeat("k", 3000) + "``"
+
+	w := NewOutputWriter()
+	w.WriteLine(line)
+	messages := w.Messages()
+	assertMessageLengths(t, messages)
+	if len(messages) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(messages))
+	}
+	for idx, msg := range messages {
+		if !strings.HasPrefix(msg, "``") || !strings.HasSuffix(msg, "``") {
+			t.Errorf("expected message %d to be a complete inline code block", idx)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// the buttons of paginated messages are removed after paginationExpiry
+	paginationExpiry = 10 * time.Minute
+
+	paginationPrevious = "pagination_previous"
+	paginationNext     = "pagination_next"
+	paginationPage     = "pagination_page"
+)
+
+var (
+	// paginations maps message IDs to the pages of the message
+	paginations = struct {
+		sync.Mutex
+		messages map[string]*pagination
+	}{messages: make(map[string]*pagination)}
+)
+
+// pagination is the state of a message that shows one of several pages
+type pagination struct {
+	pages       []*discordgo.MessageSend
+	current     int
+	requesterID string
+}
+
+// page returns the current page with buttons to flip the pages, disabled buttons if expired is true
+func (p *pagination) page(expired bool) *discordgo.MessageSend {
+	page := *p.pages[p.current]
+	if expired {
+		page.Components = []discordgo.MessageComponent{}
+		return &page
+	}
+
+	page.Components = []discordgo.MessageComponent{
+		discordgo.ActionsRow{
+			Components: []discordgo.MessageComponent{
+				discordgo.Button{
+					Label:    "◀",
+					Style:    discordgo.SecondaryButton,
+					CustomID: paginationPrevious,
+					Disabled: p.current == 0,
+				},
+				discordgo.Button{
+					Label:    fmt.Sprintf("%d/%d", p.current+1, len(p.pages)),
+					Style:    discordgo.SecondaryButton,
+					CustomID: paginationPage,
+					Disabled: true,
+				},
+				discordgo.Button{
+					Label:    "▶",
+					Style:    discordgo.SecondaryButton,
+					CustomID: paginationNext,
+					Disabled: p.current == len(p.pages)-1,
+				},
+			},
+		},
+	}
+	return &page
+}
+
+// respondPaginated sends the first page with buttons that only the author of m can use to flip the pages.
+// A single page is sent without buttons.
+func respondPaginated(s *discordgo.Session, m *discordgo.MessageCreate, pages []*discordgo.MessageSend) {
+	if len(pages) == 0 {
+		return
+	}
+	if len(pages) == 1 {
+		respondComplex(s, m, pages[0])
+		return
+	}
+
+	p := &pagination{pages: pages, requesterID: m.Author.ID}
+	msg, err := respondComplex(s, m, p.page(false))
+	if err != nil {
+		log.Printf("failed to send a paginated message: %v\n", err)
+		return
+	}
+
+	paginations.Lock()
+	paginations.messages[msg.ID] = p
+	paginations.Unlock()
+
+	time.AfterFunc(paginationExpiry, func() {
+		paginations.Lock()
+		delete(paginations.messages, msg.ID)
+		paginations.Unlock()
+
+		page := p.page(true)
+		_, err := s.ChannelMessageEditComplex(&discordgo.MessageEdit{
+			ID:         msg.ID,
+			Channel:    msg.ChannelID,
+			Content:    &page.Content,
+			Embeds:     page.Embeds,
+			Components: page.Components,
+		})
+		if err != nil {
+			log.Printf("failed to remove the buttons of a paginated message: %v\n", err)
+		}
+	})
+}
+
+// textPages converts text messages into pages
+func textPages(messages []string) []*discordgo.MessageSend {
+	pages := make([]*discordgo.MessageSend, 0, len(messages))
+	for _, msg := range messages {
+		pages = append(pages, &discordgo.MessageSend{Content: msg})
+	}
+	return pages
+}
+
+// PaginationHandler flips the page of a paginated message when one of its buttons is clicked
+func PaginationHandler(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	userID := ""
+	if i.Member != nil && i.Member.User != nil {
+		userID = i.Member.User.ID
+	} else if i.User != nil {
+		userID = i.User.ID
+	}
+
+	paginations.Lock()
+	p, ok := paginations.messages[i.Message.ID]
+	if !ok {
+		paginations.Unlock()
+		respondEphemeral(s, i, "These pages expired, please run the command again.")
+		return
+	}
+	if p.requesterID != userID {
+		paginations.Unlock()
+		respondEphemeral(s, i, "Only the user that ran the command can flip the pages.")
+		return
+	}
+
+	switch i.MessageComponentData().CustomID {
+	case paginationPrevious:
+		if p.current > 0 {
+			p.current--
+		}
+	case paginationNext:
+		if p.current < len(p.pages)-1 {
+			p.current++
+		}
+	}
+	page := p.page(false)
+	paginations.Unlock()
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    page.Content,
+			Embeds:     page.Embeds,
+			Components: page.Components,
+		},
+	})
+	if err != nil {
+		log.Printf("failed to flip the page: %v\n", err)
+	}
+}
+
+// respondEphemeral answers the interaction with a message that only the user sees
+func respondEphemeral(s *discordgo.Session, i *discordgo.InteractionCreate, content string) {
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		log.Printf("failed to answer the interaction: %v\n", err)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// permissionsFile was used before permissions were granted per guild
+	permissionsFile = "permissions.json"
+)
+
+// PermissionLevel defines which commands a user is allowed to execute
+type PermissionLevel int
+
+const (
+	// PermissionNone is the level of every user
+	PermissionNone PermissionLevel = iota
+	// PermissionModerator allows to add and delete servers
+	PermissionModerator
+	// PermissionAdmin allows to execute every command
+	PermissionAdmin
+)
+
+var (
+	userMentionRegex = regexp.MustCompile(`^<@!?(\d+)>$`)
+	roleMentionRegex = regexp.MustCompile(`^<@&(\d+)>$`)
+	snowflakeRegex   = regexp.MustCompile(`^\d+$`)
+)
+
+// String returns the name of the permission level
+func (p PermissionLevel) String() string {
+	switch p {
+	case PermissionModerator:
+		return "moderator"
+	case PermissionAdmin:
+		return "admin"
+	default:
+		return "none"
+	}
+}
+
+// MarshalText allows to store permission levels by name
+func (p PermissionLevel) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText parses the name of a permission level
+func (p *PermissionLevel) UnmarshalText(text []byte) (err error) {
+	*p, err = parsePermissionLevel(string(text))
+	return
+}
+
+func parsePermissionLevel(level string) (PermissionLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "none":
+		return PermissionNone, nil
+	case "moderator", "mod":
+		return PermissionModerator, nil
+	case "admin":
+		return PermissionAdmin, nil
+	default:
+		return PermissionNone, fmt.Errorf("unknown permission level: %s", level)
+	}
+}
+
+// Permissions maps discord user and guild role IDs to permission levels
+type Permissions struct {
+	Users map[string]PermissionLevel `json:"users,omitempty"`
+	Roles map[string]PermissionLevel `json:"roles,omitempty"`
+}
+
+// level returns the highest permission level of a user and their roles
+func (p *Permissions) level(userID string, roleIDs []string) PermissionLevel {
+	level := p.Users[userID]
+	for _, roleID := range roleIDs {
+		if p.Roles[roleID] > level {
+			level = p.Roles[roleID]
+		}
+	}
+	return level
+}
+
+// grant sets the permission level of a user or role mention, PermissionNone revokes the permissions.
+func (p *Permissions) grant(mention string, level PermissionLevel) error {
+	isRole, id, err := parsePermissionTarget(mention)
+	if err != nil {
+		return err
+	}
+
+	if p.Users == nil {
+		p.Users = make(map[string]PermissionLevel)
+	}
+	if p.Roles == nil {
+		p.Roles = make(map[string]PermissionLevel)
+	}
+
+	target := p.Users
+	if isRole {
+		target = p.Roles
+	}
+
+	if level == PermissionNone {
+		if _, ok := target[id]; !ok {
+			return errors.New("no permissions granted")
+		}
+		delete(target, id)
+	} else {
+		target[id] = level
+	}
+	return nil
+}
+
+// parsePermissionTarget parses a user mention, role mention or plain user ID
+func parsePermissionTarget(mention string) (isRole bool, id string, err error) {
+	mention = strings.TrimSpace(mention)
+
+	if matches := roleMentionRegex.FindStringSubmatch(mention); len(matches) == 2 {
+		return true, matches[1], nil
+	}
+	if matches := userMentionRegex.FindStringSubmatch(mention); len(matches) == 2 {
+		return false, matches[1], nil
+	}
+	if snowflakeRegex.MatchString(mention) {
+		return false, mention, nil
+	}
+	return false, "", errors.New("please mention a user or a role")
+}
+
+// format returns a list of all granted permissions, user and role IDs are resolved
+// to their names within the given guild if possible.
+func (p *Permissions) format(s *discordgo.Session, guildID string) []string {
+	lines := make([]string, 0, len(p.Users)+len(p.Roles))
+	for id, level := range p.Users {
+		lines = append(lines, fmt.Sprintf("%s %s", userName(s, guildID, id), level))
+	}
+	for id, level := range p.Roles {
+		lines = append(lines, fmt.Sprintf("%s %s", roleName(s, guildID, id), level))
+	}
+	return lines
+}
+
+// userName looks up the name of a user in the state cache without pinging them
+func userName(s *discordgo.Session, guildID, userID string) string {
+	if member, err := s.State.Member(guildID, userID); err == nil && member.User != nil {
+		return fmt.Sprintf("%s (%s)", Escape(member.User.String()), userID)
+	}
+	return fmt.Sprintf("user %s", Escape(userID))
+}
+
+// roleName looks up the name of a role in the state cache without pinging its members
+func roleName(s *discordgo.Session, guildID, roleID string) string {
+	if role, err := s.State.Role(guildID, roleID); err == nil {
+		return fmt.Sprintf("role %s (%s)", Escape(role.Name), roleID)
+	}
+	return fmt.Sprintf("role %s", roleID)
+}
+
+// authorLevel returns the permission level of a message's author
+func authorLevel(m *discordgo.MessageCreate) PermissionLevel {
+	var roleIDs []string
+	if m.Member != nil {
+		roleIDs = m.Member.Roles
+	}
+	return config.Guilds.Level(m.GuildID, m.Author, roleIDs)
+}
+
+// GrantHandler handles the !grant command
+func GrantHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
+	ss := strings.Fields(args)
+	if len(ss) != 2 {
+		respond(s, m, usage(m, "grant"))
+		return
+	}
+
+	level, err := parsePermissionLevel(ss[1])
+	if err != nil || level == PermissionNone {
+		respond(s, m, usage(m, "grant"))
+		return
+	}
+
+	err = config.Guilds.Grant(m.GuildID, ss[0], level)
+	if err != nil {
+		respond(s, m, err.Error())
+		return
+	}
+	respond(s, m, "Granted.")
+}
+
+// RevokeHandler handles the !revoke command
+func RevokeHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
+	if strings.TrimSpace(args) == "" {
+		respond(s, m, usage(m, "revoke"))
+		return
+	}
+
+	err := config.Guilds.Grant(m.GuildID, args, PermissionNone)
+	if err != nil {
+		respond(s, m, err.Error())
+		return
+	}
+	respond(s, m, "Revoked.")
+}
+
+// PermissionsHandler handles the !permissions command that lists all granted permissions
+func PermissionsHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
+	list := config.Guilds.FormatPermissions(s, m.GuildID)
+	if list == "" {
+		respond(s, m, "No permissions granted.")
+		return
+	}
+
+	w := NewOutputWriter()
+	w.Write(list)
+	w.Send(s, m)
+}
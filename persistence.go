@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// loadJSON reads the file name from the data directory into v.
+// A missing file is not an error and leaves v untouched.
+func loadJSON(name string, v interface{}) error {
+	data, err := ioutil.ReadFile(filepath.Join(config.DataDir, name))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// saveJSON writes v to the file name in the data directory.
+func saveJSON(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(config.DataDir, name), data)
+}
+
+// writeFileAtomic writes the data into a temporary file next to the file and renames it afterwards,
+// which either leaves the previous or the new content behind if the bot crashes.
+func writeFileAtomic(filePath string, data []byte) error {
+	dir := filepath.Dir(filePath)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, filepath.Base(filePath)+".tmp")
+	if err != nil {
+		return err
+	}
+	// does nothing after the rename succeeded
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(tmp.Name(), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filePath)
+}
+
+// rotateBackups keeps the last count versions of the file as file.1 (newest) to file.<count> (oldest).
+func rotateBackups(filePath string, count int) error {
+	if count <= 0 {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for idx := count - 1; idx > 0; idx-- {
+		err = os.Rename(fmt.Sprintf("%s.%d", filePath, idx), fmt.Sprintf("%s.%d", filePath, idx+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return writeFileAtomic(filePath+".1", data)
+}
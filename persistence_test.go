@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readTestFile(t *testing.T, filePath string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestSaveAndLoadJSON(t *testing.T) {
+	dir, restore := withTestConfig(t, "")
+	defer restore()
+
+	type state struct {
+		Names []string `json:"names"`
+	}
+
+	loaded := state{Names: []string{"untouched"}}
+	err := loadJSON("state.json", &loaded)
+	if err != nil {
+		t.Fatalf("expected a missing file not to be an error: %v", err)
+	}
+	if len(loaded.Names) != 1 || loaded.Names[0] != "untouched" {
+		t.Fatalf("expected a missing file to leave the value untouched, got %v", loaded)
+	}
+
+	err = saveJSON("state.json", state{Names: []string{"a", "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded = state{}
+	err = loadJSON("state.json", &loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(loaded.Names, ",") != "a,b" {
+		t.Fatalf("expected the saved names, got %v", loaded.Names)
+	}
+
+	// no temporary files are left behind
+	files, err := ioutil.ReadDir(filepath.Join(dir, "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if strings.Contains(file.Name(), ".tmp") {
+			t.Errorf("expected the temporary file %s to be removed", file.Name())
+		}
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, restore := withTestConfig(t, "")
+	defer restore()
+
+	filePath := filepath.Join(dir, "nested", "file.txt")
+	err := writeFileAtomic(filePath, []byte("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writeFileAtomic(filePath, []byte("second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content := readTestFile(t, filePath); content != "second" {
+		t.Fatalf("expected the file to be replaced, got %q", content)
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected the file to be readable by the owner only, got %s", info.Mode().Perm())
+	}
+
+	files, err := ioutil.ReadDir(filepath.Dir(filePath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expected only the file to be left behind, got %d files", len(files))
+	}
+}
+
+func TestRotateBackups(t *testing.T) {
+	dir, restore := withTestConfig(t, "")
+	defer restore()
+
+	filePath := filepath.Join(dir, "servers.txt")
+	os.Remove(filePath)
+
+	err := rotateBackups(filePath, 3)
+	if err != nil {
+		t.Fatalf("expected a missing file not to be backed up: %v", err)
+	}
+	if _, err := os.Stat(filePath + ".1"); !os.IsNotExist(err) {
+		t.Fatal("expected no backup of a missing file")
+	}
+
+	for version := 1; version <= 5; version++ {
+		err = rotateBackups(filePath, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = writeFileAtomic(filePath, []byte(fmt.Sprintf("version %d", version)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the first backup is the newest one
+	for idx, version := range []int{4, 3, 2} {
+		backup := fmt.Sprintf("%s.%d", filePath, idx+1)
+		if content := readTestFile(t, backup); content != fmt.Sprintf("version %d", version) {
+			t.Errorf("expected %s to contain version %d, got %q", backup, version, content)
+		}
+	}
+	if _, err := os.Stat(filePath + ".4"); !os.IsNotExist(err) {
+		t.Error("expected only 3 backups to be kept")
+	}
+	if content := readTestFile(t, filePath); content != "version 5" {
+		t.Errorf("expected the file to contain the newest version, got %q", content)
+	}
+
+	err = rotateBackups(filePath, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content := readTestFile(t, filePath+".1"); content != "version 4" {
+		t.Errorf("expected no backups to be rotated if they are disabled, got %q", content)
+	}
+}
+
+func TestSaveServerFileKeepsCommentsAndBackups(t *testing.T) {
+	_, restore := withTestConfig(t, "# my servers\n1.1.1.1:8303 # first\n2.2.2.2:8303\n")
+	defer restore()
+	config.ServerFileBackups = 2
+
+	list := config.Guilds.ServerList("")
+	err := list.Delete("2.2.2.2:8303")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = list.Add("3.3.3.3:8303 alias=third")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = saveServerFile(config.FilePath, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "# my servers\n1.1.1.1:8303 # first\n3.3.3.3:8303 alias=third\n"
+	if content := readTestFile(t, config.FilePath); content != want {
+		t.Errorf("expected the server file\n%s\ngot\n%s", want, content)
+	}
+	if content := readTestFile(t, config.FilePath+".1"); !strings.Contains(content, "2.2.2.2:8303") {
+		t.Errorf("expected the previous server file as backup, got %q", content)
+	}
+
+	loaded, err := loadServerFile(config.FilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertAddresses(t, loaded, "1.1.1.1:8303", "3.3.3.3:8303")
+	if entry, ok := loaded.Lookup("third"); !ok || entry.String() != "3.3.3.3:8303" {
+		t.Errorf("expected the alias to be saved, got %v", entry)
+	}
+}
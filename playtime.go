@@ -0,0 +1,421 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jxsl13/twapi/browser"
+)
+
+const (
+	playtimeFile = "playtime.json"
+
+	// the playtime of the last days is kept per day in order to rank the players of the last week and month
+	playtimeDayHistory = 31
+	playtimeDay        = 24 * time.Hour
+
+	// the playtime is written to disk at most once per playtimeSaveInterval
+	playtimeSaveInterval = 5 * time.Minute
+
+	// maxTopPlayers is the number of players listed by !top
+	maxTopPlayers = 15
+
+	// clients that did not finish joining the server yet
+	connectingPlayerName = "(connecting)"
+)
+
+var (
+	playtimePeriods = []struct {
+		names []string
+		days  int
+	}{
+		{[]string{"week", "7d"}, 7},
+		{[]string{"month", "30d"}, 30},
+		{[]string{"all"}, 0},
+	}
+)
+
+// LoadPlaytimeTracker reads the playtime of all players from the data directory
+func LoadPlaytimeTracker() (*PlaytimeTracker, error) {
+	tracker := &PlaytimeTracker{Players: make(map[string]*PlayerPlaytime)}
+	err := loadJSON(playtimeFile, tracker)
+	if err != nil {
+		return nil, err
+	}
+	if tracker.Players == nil {
+		tracker.Players = make(map[string]*PlayerPlaytime)
+	}
+	tracker.savedAt = time.Now()
+	return tracker, nil
+}
+
+// PlaytimeTracker accumulates how long player names were seen online, keyed by player name
+type PlaytimeTracker struct {
+	sync.Mutex
+	Players map[string]*PlayerPlaytime `json:"players"`
+
+	recordedAt time.Time
+	savedAt    time.Time
+}
+
+// PlayerPlaytime is the playtime of a single player name
+type PlayerPlaytime struct {
+	LastSeen time.Time `json:"last_seen"`
+	// resolved ip:port -> gametype -> playtime
+	Servers map[string]map[string]*playtimeRecord `json:"servers"`
+}
+
+// playtimeRecord is the playtime of a player on one server with one gametype in seconds
+type playtimeRecord struct {
+	Total int64 `json:"total"`
+	// unix time of the start of the day (UTC) -> seconds
+	Days map[int64]int64 `json:"days,omitempty"`
+}
+
+// seconds returns the playtime since the given day, a zero day returns the whole playtime
+func (r *playtimeRecord) seconds(since int64) int64 {
+	if since == 0 {
+		return r.Total
+	}
+	sum := int64(0)
+	for day, seconds := range r.Days {
+		if day >= since {
+			sum += seconds
+		}
+	}
+	return sum
+}
+
+// save must be called while holding the lock
+func (pt *PlaytimeTracker) save() error {
+	pt.prune(time.Now())
+	pt.savedAt = time.Now()
+	return saveJSON(playtimeFile, pt)
+}
+
+// prune forgets the daily playtime that is older than the history, must be called while holding the lock
+func (pt *PlaytimeTracker) prune(now time.Time) {
+	oldest := now.UTC().Truncate(playtimeDay).Add(-playtimeDayHistory * playtimeDay).Unix()
+	for _, player := range pt.Players {
+		for _, gametypes := range player.Servers {
+			for _, record := range gametypes {
+				for day := range record.Days {
+					if day < oldest {
+						delete(record.Days, day)
+					}
+				}
+			}
+		}
+	}
+}
+
+// Record adds the time since the previous poll to the playtime of every player that is online.
+func (pt *PlaytimeTracker) Record(infos []browser.ServerInfo, updatedAt time.Time) {
+	pt.Lock()
+	defer pt.Unlock()
+
+	// a poll that took too long, e.g. after the bot was suspended, only counts as a single interval
+	elapsed := updatedAt.Sub(pt.recordedAt)
+	if pt.recordedAt.IsZero() || elapsed <= 0 || elapsed > 2*config.PollInterval {
+		elapsed = config.PollInterval
+	}
+	pt.recordedAt = updatedAt
+
+	seconds := int64(elapsed.Round(time.Second).Seconds())
+	day := updatedAt.UTC().Truncate(playtimeDay).Unix()
+
+	for _, info := range infos {
+		if info.Name == "" {
+			continue
+		}
+
+		// players with the same name on the same server are only counted once
+		counted := make(map[string]bool, len(info.Players))
+		for _, player := range info.Players {
+			if player.Name == "" || player.Name == connectingPlayerName || counted[player.Name] {
+				continue
+			}
+			counted[player.Name] = true
+
+			record := pt.record(player.Name, info.Address, info.GameType)
+			record.Total += seconds
+			record.Days[day] += seconds
+			pt.Players[player.Name].LastSeen = updatedAt
+		}
+	}
+
+	if time.Since(pt.savedAt) >= playtimeSaveInterval {
+		err := pt.save()
+		if err != nil {
+			log.Printf("failed to save the playtime: %v\n", err)
+		}
+	}
+}
+
+// record must be called while holding the lock, it creates missing records
+func (pt *PlaytimeTracker) record(name, address, gametype string) *playtimeRecord {
+	player, ok := pt.Players[name]
+	if !ok {
+		player = &PlayerPlaytime{Servers: make(map[string]map[string]*playtimeRecord)}
+		pt.Players[name] = player
+	}
+	gametypes, ok := player.Servers[address]
+	if !ok {
+		gametypes = make(map[string]*playtimeRecord)
+		player.Servers[address] = gametypes
+	}
+	record, ok := gametypes[gametype]
+	if !ok {
+		record = &playtimeRecord{}
+		gametypes[gametype] = record
+	}
+	if record.Days == nil {
+		record.Days = make(map[int64]int64)
+	}
+	return record
+}
+
+// Flush writes the playtime to disk, e.g. before the bot shuts down
+func (pt *PlaytimeTracker) Flush() error {
+	pt.Lock()
+	defer pt.Unlock()
+
+	return pt.save()
+}
+
+// PlayerRanking is the playtime of a player within a period
+type PlayerRanking struct {
+	Name     string
+	Playtime time.Duration
+}
+
+// Top returns the players with the most playtime on the given servers, ordered by their playtime.
+// The gametype is matched like the filter of !online, days is the number of days including today,
+// zero days rank the whole playtime.
+func (pt *PlaytimeTracker) Top(addresses map[string]bool, gametype string, days int, now time.Time, n int) []PlayerRanking {
+	pt.Lock()
+	defer pt.Unlock()
+
+	since := playtimeSince(days, now)
+	rankings := make([]PlayerRanking, 0, len(pt.Players))
+	for name, player := range pt.Players {
+		sum := int64(0)
+		for address, gametypes := range player.Servers {
+			if !addresses[address] {
+				continue
+			}
+			for gt, record := range gametypes {
+				if gametype == "" || strings.Contains(strings.ToLower(gt), gametype) {
+					sum += record.seconds(since)
+				}
+			}
+		}
+		if sum > 0 {
+			rankings = append(rankings, PlayerRanking{Name: name, Playtime: time.Duration(sum) * time.Second})
+		}
+	}
+
+	sort.SliceStable(rankings, func(i, j int) bool {
+		if rankings[i].Playtime == rankings[j].Playtime {
+			return rankings[i].Name < rankings[j].Name
+		}
+		return rankings[i].Playtime > rankings[j].Playtime
+	})
+	if len(rankings) > n {
+		rankings = rankings[:n]
+	}
+	return rankings
+}
+
+// PlayerPlaytimeStats is the playtime of a single player on the servers of a guild
+type PlayerPlaytimeStats struct {
+	Name     string
+	LastSeen time.Time
+	// the playtime per period of playtimePeriods
+	Periods []time.Duration
+	// the whole playtime per resolved ip:port and per gametype
+	Servers   map[string]time.Duration
+	GameTypes map[string]time.Duration
+}
+
+// Player returns the playtime of the player on the given servers. The name is matched exactly,
+// if there is no such player, a single player with a case insensitive match is returned.
+func (pt *PlaytimeTracker) Player(name string, addresses map[string]bool, now time.Time) (stats PlayerPlaytimeStats, ok bool) {
+	pt.Lock()
+	defer pt.Unlock()
+
+	player, ok := pt.Players[name]
+	if !ok {
+		matches := 0
+		for n, p := range pt.Players {
+			if strings.EqualFold(n, name) {
+				name, player = n, p
+				matches++
+			}
+		}
+		if matches != 1 {
+			return stats, false
+		}
+	}
+
+	stats = PlayerPlaytimeStats{
+		Name:      name,
+		LastSeen:  player.LastSeen,
+		Periods:   make([]time.Duration, len(playtimePeriods)),
+		Servers:   make(map[string]time.Duration),
+		GameTypes: make(map[string]time.Duration),
+	}
+
+	found := false
+	for address, gametypes := range player.Servers {
+		if !addresses[address] {
+			continue
+		}
+		for gametype, record := range gametypes {
+			found = true
+			for idx, period := range playtimePeriods {
+				stats.Periods[idx] += time.Duration(record.seconds(playtimeSince(period.days, now))) * time.Second
+			}
+			stats.Servers[address] += time.Duration(record.Total) * time.Second
+			stats.GameTypes[gametype] += time.Duration(record.Total) * time.Second
+		}
+	}
+	return stats, found
+}
+
+// playtimeSince returns the start of the first day of the period, zero days return zero
+func playtimeSince(days int, now time.Time) int64 {
+	if days <= 0 {
+		return 0
+	}
+	return now.UTC().Truncate(playtimeDay).Add(-time.Duration(days-1) * playtimeDay).Unix()
+}
+
+// guildPlaytimeAddresses returns the resolved addresses of the guild's servers, hidden servers are excluded.
+func guildPlaytimeAddresses(list *ConcurrentServerList) map[string]bool {
+	addresses := make(map[string]bool, list.Len())
+	for _, entry := range list.Entries() {
+		if entry.Hidden {
+			continue
+		}
+		for _, addr := range entry.Addrs {
+			addresses[addr.String()] = true
+		}
+	}
+	return addresses
+}
+
+// formatPlaytimes formats the playtimes sorted by their duration, at most n of them
+func formatPlaytimes(playtimes map[string]time.Duration, label func(string) string, n int) string {
+	keys := make([]string, 0, len(playtimes))
+	for key := range playtimes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if playtimes[keys[i]] == playtimes[keys[j]] {
+			return keys[i] < keys[j]
+		}
+		return playtimes[keys[i]] > playtimes[keys[j]]
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, fmt.Sprintf("%s %s", label(key), formatDuration(playtimes[key])))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// PlaytimeHandler handles the !playtime command that shows how long a player played on the servers of the guild
+func PlaytimeHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
+	name := strings.TrimSpace(args)
+	if name == "" {
+		respond(s, m, usage(m, "playtime"))
+		return
+	}
+
+	list := config.Guilds.ServerList(m.GuildID)
+	stats, ok := config.Playtime.Player(name, guildPlaytimeAddresses(list), time.Now())
+	if !ok {
+		respond(s, m, fmt.Sprintf("%s was not seen on any server yet.", WrapInInlineCodeBlock(name)))
+		return
+	}
+
+	w := NewOutputWriter()
+	w.WriteLine(fmt.Sprintf("%s - last seen %s", WrapInInlineCodeBlock(stats.Name), formatAge(stats.LastSeen)))
+
+	periods := make([]string, 0, len(playtimePeriods))
+	for idx, period := range playtimePeriods {
+		periods = append(periods, fmt.Sprintf("%s: **%s**", period.names[0], formatDuration(stats.Periods[idx])))
+	}
+	w.WriteLine(strings.Join(periods, " | "))
+
+	w.WriteLine("Gametypes: " + formatPlaytimes(stats.GameTypes, Escape, 5))
+	w.WriteLine("Servers: " + formatPlaytimes(stats.Servers, func(address string) string {
+		entry, ok := list.Resolved(address)
+		if !ok {
+			return address
+		}
+		return Escape(entry.Label())
+	}, 5))
+
+	w.Send(s, m)
+}
+
+// parseTopArgs splits the arguments of !top into the gametype and the period
+func parseTopArgs(args string) (gametype string, days int, name string) {
+	days, name = playtimePeriods[0].days, playtimePeriods[0].names[0]
+
+	gametypes := make([]string, 0, 1)
+	for _, field := range strings.Fields(strings.ToLower(args)) {
+		matched := false
+		for _, period := range playtimePeriods {
+			for _, n := range period.names {
+				if field == n {
+					days, name, matched = period.days, period.names[0], true
+				}
+			}
+		}
+		if !matched {
+			gametypes = append(gametypes, field)
+		}
+	}
+	return strings.Join(gametypes, " "), days, name
+}
+
+// TopHandler handles the !top command that ranks the players by their playtime on the servers of the guild
+func TopHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
+	gametype, days, period := parseTopArgs(args)
+
+	list := config.Guilds.ServerList(m.GuildID)
+	rankings := config.Playtime.Top(guildPlaytimeAddresses(list), gametype, days, time.Now(), maxTopPlayers)
+	if len(rankings) == 0 {
+		respond(s, m, "no players were seen yet.")
+		return
+	}
+
+	title := "all gametypes"
+	if gametype != "" {
+		title = Escape(gametype)
+	}
+	if days > 0 {
+		title = fmt.Sprintf("%s, last %d days", title, days)
+	} else {
+		title = fmt.Sprintf("%s, %s time", title, period)
+	}
+
+	w := NewOutputWriter()
+	w.WriteLine(fmt.Sprintf("**Top players (%s)**", title))
+	for idx, ranking := range rankings {
+		w.WriteLine(fmt.Sprintf("%2d. %s %s", idx+1, WrapInInlineCodeBlock(fmt.Sprintf("%-16s", ranking.Name)), formatDuration(ranking.Playtime)))
+	}
+
+	w.Send(s, m)
+}
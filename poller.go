@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jxsl13/twapi/browser"
+)
+
+// NewServerInfoCache creates a new empty cache
+func NewServerInfoCache() *ServerInfoCache {
+	return &ServerInfoCache{infos: make([]browser.ServerInfo, 0)}
+}
+
+// ServerInfoListener is called with every newly polled snapshot.
+// The passed infos must not be modified.
+type ServerInfoListener func(infos []browser.ServerInfo, updatedAt time.Time)
+
+// ServerInfoCache contains the most recently polled server infos and allows for concurrent access
+type ServerInfoCache struct {
+	sync.Mutex
+	infos     []browser.ServerInfo
+	updatedAt time.Time
+	listeners []ServerInfoListener
+}
+
+// Subscribe registers a listener that is called after every update of the cache
+func (c *ServerInfoCache) Subscribe(listener ServerInfoListener) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.listeners = append(c.listeners, listener)
+}
+
+// Update replaces the cached server infos with a newly polled snapshot
+// and notifies all listeners in the order they subscribed.
+func (c *ServerInfoCache) Update(infos []browser.ServerInfo) {
+	c.Lock()
+	c.infos = infos
+	c.updatedAt = time.Now()
+	updatedAt := c.updatedAt
+	listeners := make([]ServerInfoListener, len(c.listeners))
+	copy(listeners, c.listeners)
+	c.Unlock()
+
+	for _, listener := range listeners {
+		listener(infos, updatedAt)
+	}
+}
+
+// Snapshot returns a copy of the cached server infos and the time they were polled at.
+// The returned time is zero if nothing has been polled yet.
+func (c *ServerInfoCache) Snapshot() (infos []browser.ServerInfo, updatedAt time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	infos = make([]browser.ServerInfo, len(c.infos))
+	copy(infos, c.infos)
+	return infos, c.updatedAt
+}
+
+// pollServerInfos refreshes the server info cache every interval until stop is closed.
+func pollServerInfos(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		begin := time.Now()
+		servers := config.Guilds.AllServers()
+		infos, latencies := fetchServerInfosWithLatencies(servers)
+		config.Uptime.Record(servers, infos, latencies, time.Now())
+		config.ServerInfos.Update(infos)
+
+		if took := time.Since(begin); took > interval {
+			log.Printf("polling %d servers took %s, which is longer than the poll interval of %s\n", len(infos), took, interval)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// resolveServerLists looks up the registered hostnames again every interval until stop is closed.
+func resolveServerLists(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		for _, list := range config.Guilds.ServerLists() {
+			list.Resolve()
+		}
+	}
+}
+
+// formatAge returns a human readable representation of how long ago t was.
+func formatAge(t time.Time) string {
+	age := time.Since(t)
+	switch {
+	case age < time.Second:
+		return "just now"
+	case age < time.Minute:
+		return fmt.Sprintf("%ds ago", int(age.Seconds()))
+	case age < time.Hour:
+		return fmt.Sprintf("%dm%02ds ago", int(age.Minutes()), int(age.Seconds())%60)
+	case age < 24*time.Hour:
+		return fmt.Sprintf("%dh%02dm ago", int(age.Hours()), int(age.Minutes())%60)
+	default:
+		return fmt.Sprintf("%dd%02dh ago", int(age.Hours())/24, int(age.Hours())%24)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// serverFileState is used to detect whether a server file was changed on disk
+type serverFileState struct {
+	modTime time.Time
+	size    int64
+}
+
+func (s serverFileState) equal(other serverFileState) bool {
+	return s.modTime.Equal(other.modTime) && s.size == other.size
+}
+
+// NewServerFileWatcher creates a watcher that did not see any server files yet
+func NewServerFileWatcher() *ServerFileWatcher {
+	return &ServerFileWatcher{states: make(map[string]serverFileState)}
+}
+
+// ServerFileWatcher reloads the server files of all guilds after they were changed on disk
+type ServerFileWatcher struct {
+	sync.Mutex
+	states      map[string]serverFileState
+	initialized bool
+}
+
+// Reload reads the server files that changed since the previous call and replaces
+// the registered servers with their content. force also reloads unchanged files.
+// Missing files are skipped in order not to drop servers that were not saved yet.
+// Files that were saved by the bot itself are skipped as well as changed files
+// whose server list has unsaved changes, unless force is set.
+func (w *ServerFileWatcher) Reload(s *discordgo.Session, force bool) {
+	w.Lock()
+	defer w.Unlock()
+	defer func() { w.initialized = true }()
+
+	for filePath, list := range config.Guilds.ServerFiles() {
+		info, err := os.Stat(filePath)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Printf("failed to check the server file %s: %v\n", filePath, err)
+			}
+			continue
+		}
+
+		state := serverFileState{info.ModTime(), info.Size()}
+		previous, seen := w.states[filePath]
+		w.states[filePath] = state
+		if !force && (!w.initialized || (seen && previous.equal(state))) {
+			// the first call only remembers the current state
+			continue
+		}
+
+		if !force && savedByBot(filePath) {
+			continue
+		}
+
+		if !force && list.Unsaved() {
+			log.Printf("the server file %s was changed, but not reloaded, because the server list has unsaved changes\n", filePath)
+			announceServerFile(s, list, []string{"The server file was changed, but the server list has unsaved changes that would be lost. " +
+				"Save the server list to overwrite the file or send SIGHUP to the bot in order to reload the file and discard the changes."})
+			continue
+		}
+
+		loaded, err := loadServerFile(filePath)
+		if err != nil {
+			log.Printf("failed to reload the server file %s: %v\n", filePath, err)
+			continue
+		}
+
+		diff := list.Replace(loaded)
+		if diff.Empty() {
+			continue
+		}
+
+		changes := diff.String()
+		log.Printf("reloaded the server file %s:\n%s", filePath, changes)
+
+		out := NewOutputWriter()
+		out.WriteLine("Reloaded the server list:")
+		out.Write(Escape(changes))
+		announceServerFile(s, list, out.Messages())
+	}
+}
+
+// announceServerFile sends the messages into the log channels of the guilds that use the server list
+func announceServerFile(s *discordgo.Session, list *ConcurrentServerList, messages []string) {
+	for _, channelID := range config.Guilds.LogChannels(list) {
+		for _, msg := range messages {
+			_, err := s.ChannelMessageSend(channelID, msg)
+			if err != nil {
+				log.Printf("failed to announce the reloaded server list: %v\n", err)
+				break
+			}
+		}
+	}
+}
+
+// watchServerFiles checks the server files for changes every interval and reloads all of them
+// when the process receives SIGHUP, until stop is closed. A zero interval only reloads on SIGHUP.
+func watchServerFiles(s *discordgo.Session, w *ServerFileWatcher, interval time.Duration, stop <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	// remember the current state of the files
+	w.Reload(s, false)
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-hup:
+			log.Println("received SIGHUP, reloading the server files")
+			w.Reload(s, true)
+		case <-tick:
+			w.Reload(s, false)
+		}
+	}
+}
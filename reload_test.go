@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// withTestConfig replaces the configuration with one that keeps its files in a temporary directory
+// and uses the servers of the server file as list of the default guild.
+func withTestConfig(t *testing.T, servers string) (dir string, restore func()) {
+	dir, err := ioutil.TempDir("", "teeworlds-discord-bot")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := config
+	config = &Config{
+		FilePath: filepath.Join(dir, "servers.txt"),
+		DataDir:  filepath.Join(dir, "data"),
+	}
+	restore = func() {
+		config = previous
+		os.RemoveAll(dir)
+	}
+
+	err = ioutil.WriteFile(config.FilePath, []byte(servers), 0600)
+	if err != nil {
+		restore()
+		t.Fatal(err)
+	}
+	list, err := loadServerFile(config.FilePath)
+	if err != nil {
+		restore()
+		t.Fatal(err)
+	}
+	config.Guilds, err = LoadGuildConfigs("", list, nil)
+	if err != nil {
+		restore()
+		t.Fatal(err)
+	}
+	return dir, restore
+}
+
+func registeredAddresses(list *ConcurrentServerList) []string {
+	addresses := []string{}
+	for _, entry := range list.Entries() {
+		addresses = append(addresses, entry.String())
+	}
+	return addresses
+}
+
+func assertAddresses(t *testing.T, list *ConcurrentServerList, want ...string) {
+	t.Helper()
+	if want == nil {
+		want = []string{}
+	}
+	if got := registeredAddresses(list); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected the servers %v, got %v", want, got)
+	}
+}
+
+func TestServerFileWatcherReloadsExternalChanges(t *testing.T) {
+	_, restore := withTestConfig(t, "1.1.1.1:8303\n")
+	defer restore()
+
+	list := config.Guilds.ServerList("")
+	w := NewServerFileWatcher()
+	w.Reload(nil, false)
+
+	err := ioutil.WriteFile(config.FilePath, []byte("1.1.1.1:8303\n2.2.2.2:8303\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Reload(nil, false)
+	assertAddresses(t, list, "1.1.1.1:8303", "2.2.2.2:8303")
+}
+
+func TestServerFileWatcherSkipsOwnSaves(t *testing.T) {
+	_, restore := withTestConfig(t, "1.1.1.1:8303\n")
+	defer restore()
+
+	list := config.Guilds.ServerList("")
+	w := NewServerFileWatcher()
+	w.Reload(nil, false)
+
+	err := list.Add("2.2.2.2:8303")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = saveServerFile(config.FilePath, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Unsaved() {
+		t.Fatal("expected the list to be saved")
+	}
+
+	// added after the save, the save must not reload the file and drop it
+	err = list.Add("3.3.3.3:8303")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Reload(nil, false)
+	assertAddresses(t, list, "1.1.1.1:8303", "2.2.2.2:8303", "3.3.3.3:8303")
+}
+
+func TestServerFileWatcherKeepsUnsavedChanges(t *testing.T) {
+	_, restore := withTestConfig(t, "1.1.1.1:8303\n")
+	defer restore()
+
+	list := config.Guilds.ServerList("")
+	w := NewServerFileWatcher()
+	w.Reload(nil, false)
+
+	err := list.Delete("1.1.1.1:8303")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !list.Unsaved() {
+		t.Fatal("expected the list to have unsaved changes")
+	}
+
+	err = ioutil.WriteFile(config.FilePath, []byte("1.1.1.1:8303\n4.4.4.4:8303\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Reload(nil, false)
+	assertAddresses(t, list)
+
+	// SIGHUP reloads the file anyway
+	w.Reload(nil, true)
+	assertAddresses(t, list, "1.1.1.1:8303", "4.4.4.4:8303")
+	if list.Unsaved() {
+		t.Fatal("expected the reloaded list not to have unsaved changes")
+	}
+}
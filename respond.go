@@ -0,0 +1,146 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// interactions must be answered within three seconds, otherwise they are deferred.
+	interactionDeferAfter = 2 * time.Second
+)
+
+// states of an interaction reply
+const (
+	replyPending = iota
+	replyDeferred
+	replySent
+)
+
+var (
+	// interactionReplies maps the message events that are created for slash commands to their interaction
+	interactionReplies = struct {
+		sync.Mutex
+		replies map[*discordgo.MessageCreate]*interactionReply
+	}{replies: make(map[*discordgo.MessageCreate]*interactionReply)}
+)
+
+// respond sends a message into the channel the command was issued in,
+// or answers the slash command interaction.
+func respond(s *discordgo.Session, m *discordgo.MessageCreate, content string) (*discordgo.Message, error) {
+	return respondComplex(s, m, &discordgo.MessageSend{Content: content})
+}
+
+// respondComplex is like respond, but allows to send embeds and files
+func respondComplex(s *discordgo.Session, m *discordgo.MessageCreate, data *discordgo.MessageSend) (*discordgo.Message, error) {
+	interactionReplies.Lock()
+	reply, ok := interactionReplies.replies[m]
+	interactionReplies.Unlock()
+
+	if ok {
+		return reply.send(s, data)
+	}
+	return s.ChannelMessageSendComplex(m.ChannelID, data)
+}
+
+// interactionReply keeps track of how far a slash command interaction has been answered.
+type interactionReply struct {
+	sync.Mutex
+	interaction *discordgo.Interaction
+	state       int
+	timer       *time.Timer
+}
+
+// newInteractionReply registers m as message event of the interaction and defers
+// the interaction response if the handler does not respond in time.
+func newInteractionReply(s *discordgo.Session, m *discordgo.MessageCreate, i *discordgo.Interaction) *interactionReply {
+	reply := &interactionReply{interaction: i}
+	reply.timer = time.AfterFunc(interactionDeferAfter, func() { reply.deferResponse(s) })
+
+	interactionReplies.Lock()
+	interactionReplies.replies[m] = reply
+	interactionReplies.Unlock()
+	return reply
+}
+
+func (r *interactionReply) deferResponse(s *discordgo.Session) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.state != replyPending {
+		return
+	}
+
+	r.state = replyDeferred
+	s.InteractionRespond(r.interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+}
+
+func (r *interactionReply) send(s *discordgo.Session, data *discordgo.MessageSend) (*discordgo.Message, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	embeds := data.Embeds
+	if data.Embed != nil {
+		embeds = append(embeds, data.Embed)
+	}
+	files := data.Files
+	if data.File != nil {
+		files = append(files, data.File)
+	}
+
+	switch r.state {
+	case replyPending:
+		r.timer.Stop()
+		err := s.InteractionRespond(r.interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content:    data.Content,
+				Embeds:     embeds,
+				Files:      files,
+				Components: data.Components,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		r.state = replySent
+		return s.InteractionResponse(r.interaction)
+	case replyDeferred:
+		r.state = replySent
+		edit := &discordgo.WebhookEdit{Content: &data.Content, Files: files}
+		if len(embeds) > 0 {
+			edit.Embeds = &embeds
+		}
+		if len(data.Components) > 0 {
+			edit.Components = &data.Components
+		}
+		return s.InteractionResponseEdit(r.interaction, edit)
+	default:
+		return s.FollowupMessageCreate(r.interaction, true, &discordgo.WebhookParams{
+			Content:    data.Content,
+			Embeds:     embeds,
+			Files:      files,
+			Components: data.Components,
+		})
+	}
+}
+
+// finish unregisters the message event and answers the interaction,
+// if the handler did not send any response.
+func (r *interactionReply) finish(s *discordgo.Session, m *discordgo.MessageCreate) {
+	interactionReplies.Lock()
+	delete(interactionReplies.replies, m)
+	interactionReplies.Unlock()
+
+	r.Lock()
+	state := r.state
+	r.Unlock()
+
+	if state != replySent {
+		r.send(s, &discordgo.MessageSend{Content: "Done."})
+	}
+}
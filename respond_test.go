@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// recordedRequest is a request that the discord session sent
+type recordedRequest struct {
+	Method string
+	Path   string
+	Body   string
+}
+
+// recordingTransport answers every request of a discord session with an empty message and records it
+type recordingTransport struct {
+	sync.Mutex
+	requests []recordedRequest
+	// onRequest is called before a request is answered if set
+	onRequest func()
+}
+
+func (rt *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := []byte{}
+	if req.Body != nil {
+		body, _ = ioutil.ReadAll(req.Body)
+	}
+
+	rt.Lock()
+	rt.requests = append(rt.requests, recordedRequest{
+		Method: req.Method,
+		Path:   strings.TrimPrefix(req.URL.Path, "/api/v"+discordgo.APIVersion),
+		Body:   string(body),
+	})
+	rt.Unlock()
+
+	if rt.onRequest != nil {
+		rt.onRequest()
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(bytes.NewReader([]byte(`{"id":"1","channel_id":"2"}`))),
+		Request:    req,
+	}, nil
+}
+
+func (rt *recordingTransport) Requests() []recordedRequest {
+	rt.Lock()
+	defer rt.Unlock()
+	return append([]recordedRequest(nil), rt.requests...)
+}
+
+func newRecordingSession(t *testing.T) (*discordgo.Session, *recordingTransport) {
+	s, err := discordgo.New("Bot token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := &recordingTransport{}
+	s.Client = &http.Client{Transport: rt}
+	return s, rt
+}
+
+func newTestInteraction() *discordgo.Interaction {
+	return &discordgo.Interaction{ID: "10", AppID: "20", Token: "token", Type: discordgo.InteractionApplicationCommand}
+}
+
+// interactionResponseType returns the type of the interaction response in the body of the callback request
+func interactionResponseType(t *testing.T, req recordedRequest) discordgo.InteractionResponseType {
+	resp := discordgo.InteractionResponse{}
+	err := json.Unmarshal([]byte(req.Body), &resp)
+	if err != nil {
+		t.Fatalf("invalid interaction response %q: %v", req.Body, err)
+	}
+	return resp.Type
+}
+
+func assertRequests(t *testing.T, got []recordedRequest, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("expected %d requests %v, got %d: %v", len(want), want, len(got), got)
+	}
+	for idx, req := range got {
+		if req.Method+" "+req.Path != want[idx] {
+			t.Errorf("request %d: expected %s, got %s %s", idx, want[idx], req.Method, req.Path)
+		}
+	}
+}
+
+func TestInteractionReplyPendingByDefault(t *testing.T) {
+	s, _ := newRecordingSession(t)
+	m := &discordgo.MessageCreate{Message: &discordgo.Message{}}
+	reply := newInteractionReply(s, m, newTestInteraction())
+	defer reply.finish(s, m)
+
+	if reply.state != replyPending {
+		t.Fatalf("expected a new reply to be pending, got state %d", reply.state)
+	}
+}
+
+func TestInteractionReplyRespondAndFollowup(t *testing.T) {
+	s, rt := newRecordingSession(t)
+	m := &discordgo.MessageCreate{Message: &discordgo.Message{}}
+	reply := newInteractionReply(s, m, newTestInteraction())
+
+	_, err := respond(s, m, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = respond(s, m, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply.finish(s, m)
+	// a late timer must not answer the interaction again
+	reply.deferResponse(s)
+
+	requests := rt.Requests()
+	assertRequests(t, requests,
+		"POST /interactions/10/token/callback",
+		"GET /webhooks/20/token/messages/@original",
+		"POST /webhooks/20/token",
+	)
+	if typ := interactionResponseType(t, requests[0]); typ != discordgo.InteractionResponseChannelMessageWithSource {
+		t.Errorf("expected a channel message response, got type %d", typ)
+	}
+	if !strings.Contains(requests[2].Body, "second") {
+		t.Errorf("expected the followup to contain the second message, got %s", requests[2].Body)
+	}
+
+	interactionReplies.Lock()
+	_, ok := interactionReplies.replies[m]
+	interactionReplies.Unlock()
+	if ok {
+		t.Error("expected finish to unregister the message event")
+	}
+}
+
+func TestInteractionReplyDeferred(t *testing.T) {
+	s, rt := newRecordingSession(t)
+	m := &discordgo.MessageCreate{Message: &discordgo.Message{}}
+	reply := newInteractionReply(s, m, newTestInteraction())
+
+	reply.deferResponse(s)
+	_, err := respond(s, m, "late")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = respond(s, m, "later")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply.finish(s, m)
+
+	requests := rt.Requests()
+	assertRequests(t, requests,
+		"POST /interactions/10/token/callback",
+		"PATCH /webhooks/20/token/messages/@original",
+		"POST /webhooks/20/token",
+	)
+	if typ := interactionResponseType(t, requests[0]); typ != discordgo.InteractionResponseDeferredChannelMessageWithSource {
+		t.Errorf("expected a deferred response, got type %d", typ)
+	}
+	if !strings.Contains(requests[1].Body, "late") {
+		t.Errorf("expected the deferred response to be replaced by the first message, got %s", requests[1].Body)
+	}
+}
+
+func TestInteractionReplyFinishWithoutResponse(t *testing.T) {
+	s, rt := newRecordingSession(t)
+	m := &discordgo.MessageCreate{Message: &discordgo.Message{}}
+	reply := newInteractionReply(s, m, newTestInteraction())
+
+	reply.finish(s, m)
+
+	requests := rt.Requests()
+	assertRequests(t, requests,
+		"POST /interactions/10/token/callback",
+		"GET /webhooks/20/token/messages/@original",
+	)
+	if !strings.Contains(requests[0].Body, "Done.") {
+		t.Errorf("expected finish to answer the interaction, got %s", requests[0].Body)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Command is a bot command that can be executed by users
+type Command struct {
+	Name        string
+	Aliases     []string
+	Arguments   string
+	Description string
+	// Details are shown by !help <command>
+	Details string
+	// Permission is the level that is required to execute the command
+	Permission  PermissionLevel
+	Middlewares []MessageCreateMiddleware
+	Handler     MessageCreateHandler
+
+	handler MessageCreateHandler
+}
+
+// Syntax returns how the command is used with the given prefix
+func (c *Command) Syntax(prefix string) string {
+	if c.Arguments == "" {
+		return prefix + c.Name
+	}
+	return fmt.Sprintf("%s%s %s", prefix, c.Name, c.Arguments)
+}
+
+// Handle executes the command's middleware chain and handler
+func (c *Command) Handle(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
+	c.handler(s, m, args)
+}
+
+// NewCommandRouter creates an empty router
+func NewCommandRouter() *CommandRouter {
+	return &CommandRouter{lookup: make(map[string]*Command)}
+}
+
+// CommandRouter maps command names and aliases to commands
+type CommandRouter struct {
+	commands []*Command
+	lookup   map[string]*Command
+}
+
+// Register adds a command, its name and aliases must not be registered yet.
+func (r *CommandRouter) Register(c *Command) error {
+	names := append([]string{c.Name}, c.Aliases...)
+	for _, name := range names {
+		if _, ok := r.lookup[name]; ok {
+			return fmt.Errorf("command name already registered: %s", name)
+		}
+	}
+
+	// the permission check is the outermost middleware
+	c.handler = c.Handler
+	for idx := len(c.Middlewares) - 1; idx >= 0; idx-- {
+		c.handler = c.Middlewares[idx](c.handler)
+	}
+	if c.Permission > PermissionNone {
+		c.handler = permissionMessageCreateMiddleware(c.Permission)(c.handler)
+	}
+
+	for _, name := range names {
+		r.lookup[name] = c
+	}
+	r.commands = append(r.commands, c)
+	return nil
+}
+
+// Lookup finds a command by its name or one of its aliases
+func (r *CommandRouter) Lookup(name string) (*Command, bool) {
+	c, ok := r.lookup[strings.ToLower(name)]
+	return c, ok
+}
+
+// Commands returns all commands in the order they were registered in
+func (r *CommandRouter) Commands() []*Command {
+	return r.commands
+}
+
+// usage returns the usage hint of a registered command with the prefix of the guild the message was sent in
+func usage(m *discordgo.MessageCreate, name string) string {
+	c, ok := config.Commands.Lookup(name)
+	if !ok {
+		return ""
+	}
+	return "usage: " + c.Syntax(config.Guilds.Prefix(m.GuildID))
+}
+
+// HelpHandler shows the commands the user is allowed to execute or the detailed usage of a single command
+func HelpHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
+	level := authorLevel(m)
+	prefix := config.Guilds.Prefix(m.GuildID)
+	name := strings.TrimPrefix(strings.TrimSpace(args), prefix)
+
+	if name != "" {
+		c, ok := config.Commands.Lookup(name)
+		if !ok || c.Permission > level {
+			respond(s, m, fmt.Sprintf("unknown command: %s", Escape(name)))
+			return
+		}
+
+		w := NewOutputWriter()
+		w.WriteLine(fmt.Sprintf("**%s**", Escape(c.Syntax(prefix))))
+		w.WriteLine(c.Description)
+		if c.Details != "" {
+			w.WriteLine(c.Details)
+		}
+		if len(c.Aliases) > 0 {
+			w.WriteLine(fmt.Sprintf("Aliases: %s", Escape(prefix+strings.Join(c.Aliases, ", "+prefix))))
+		}
+		if c.Permission > PermissionNone {
+			w.WriteLine(fmt.Sprintf("Requires the %s permission.", c.Permission))
+		}
+		w.Send(s, m)
+		return
+	}
+
+	w := NewOutputWriter()
+	w.WriteLine("Teeworlds Discord Bot by jxsl13. Have fun.")
+	w.WriteLine("Commands:")
+
+	for _, c := range config.Commands.Commands() {
+		if c.Permission > level {
+			continue
+		}
+
+		line := fmt.Sprintf("	**%s** - %s", Escape(c.Syntax(prefix)), c.Description)
+		if len(c.Aliases) > 0 {
+			line += fmt.Sprintf("(**%s**)", Escape(prefix+strings.Join(c.Aliases, ", "+prefix)))
+		}
+		w.WriteLine(line)
+	}
+	w.WriteLine(fmt.Sprintf("Use **%s** for more details.", Escape(prefix+"help <command>")))
+
+	w.Send(s, m)
+}
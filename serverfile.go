@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var (
+	// serverFileLock prevents concurrent saves from mixing up the backups
+	serverFileLock sync.Mutex
+	// savedServerFiles maps the server files to the hash of the content the bot wrote last,
+	// it is guarded by serverFileLock.
+	savedServerFiles = make(map[string][sha256.Size]byte)
+)
+
+// loadServerFile reads a file that contains one ip:port or hostname:port per line, lines starting with # are skipped.
+// The address may be followed by metadata, see parseServerLine.
+// Hostnames that cannot be resolved yet are kept and resolved again later.
+func loadServerFile(filePath string) (*ConcurrentServerList, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	list := NewConcurrentServerList(0)
+	sc := bufio.NewScanner(file)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		entry, err := parseServerLine(line)
+		if err != nil {
+			log.Printf("'%s' %v, skipping..\n", line, err)
+			continue
+		}
+
+		err = entry.resolve()
+		if err != nil {
+			log.Printf("failed to resolve %s, retrying later: %v\n", entry.String(), err)
+		}
+
+		// duplicates are skipped
+		err = list.add(entry)
+		if err != nil {
+			log.Printf("'%s' %v, skipping..\n", line, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// saveServerFile writes the server list into the file, one ip:port or hostname:port per line.
+// Comments, empty lines and the order of the servers that are already part of the file are kept,
+// new servers are appended. The previous file is kept as backup.
+func saveServerFile(filePath string, list *ConcurrentServerList) error {
+	serverFileLock.Lock()
+	defer serverFileLock.Unlock()
+
+	// changes that happen while saving are not part of the file
+	changes := list.Changes()
+	servers := list.Entries()
+	registered := make(map[string]*ServerEntry, len(servers))
+	for idx := range servers {
+		registered[servers[idx].String()] = &servers[idx]
+	}
+
+	lines, err := readServerFileLines(filePath)
+	if err != nil {
+		return err
+	}
+
+	sb := strings.Builder{}
+	written := make(map[string]bool, len(servers))
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			sb.WriteString(line + "\n")
+			continue
+		}
+
+		entry, err := parseServerLine(trimmed)
+		valid := err == nil
+		if !valid {
+			// invalid metadata, the line is replaced if the server is still registered
+			entry, err = parseAddress(strings.Fields(trimmed)[0])
+			if err != nil {
+				continue
+			}
+		}
+		address := entry.String()
+		server, ok := registered[address]
+		if !ok || written[address] {
+			// removed server or duplicate line
+			continue
+		}
+		written[address] = true
+
+		if valid && entry.sameMetadata(server) {
+			sb.WriteString(line + "\n")
+			continue
+		}
+		sb.WriteString(formatServerLine(server))
+		if _, comment, err := splitServerLine(trimmed); err == nil && comment != "" {
+			sb.WriteString(" " + comment)
+		}
+		sb.WriteString("\n")
+	}
+
+	for _, server := range servers {
+		if !written[server.String()] {
+			sb.WriteString(formatServerLine(&server) + "\n")
+		}
+	}
+
+	err = rotateBackups(filePath, config.ServerFileBackups)
+	if err != nil {
+		return err
+	}
+
+	data := []byte(sb.String())
+	err = writeFileAtomic(filePath, data)
+	if err != nil {
+		return err
+	}
+	savedServerFiles[filePath] = sha256.Sum256(data)
+	list.Saved(changes)
+	return nil
+}
+
+// savedByBot returns true if the server file contains what the bot saved last
+func savedByBot(filePath string) bool {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return false
+	}
+
+	serverFileLock.Lock()
+	defer serverFileLock.Unlock()
+
+	hash, ok := savedServerFiles[filePath]
+	return ok && hash == sha256.Sum256(data)
+}
+
+// parseServerLine parses an address that is optionally followed by metadata and a comment:
+//
+//	ger.ourclan.tw:8303 alias=ger1 group=eu,ctf description="German CTF" hidden # comment
+func parseServerLine(line string) (*ServerEntry, error) {
+	fields, _, err := splitServerLine(line)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("invalid address format")
+	}
+
+	entry, err := parseAddress(fields[0])
+	if err != nil {
+		return nil, err
+	}
+
+	for _, field := range fields[1:] {
+		kv := strings.SplitN(field, "=", 2)
+		key, value := strings.ToLower(kv[0]), ""
+		if len(kv) == 2 {
+			value = kv[1]
+		}
+
+		switch key {
+		case "alias":
+			if !labelRegex.MatchString(value) {
+				return nil, fmt.Errorf("invalid alias: %s", value)
+			}
+			entry.Alias = value
+		case "group", "groups":
+			for _, group := range strings.Split(value, ",") {
+				group = strings.ToLower(group)
+				if !labelRegex.MatchString(group) {
+					return nil, fmt.Errorf("invalid group: %s", group)
+				}
+				if !entry.InGroup(group) {
+					entry.Groups = append(entry.Groups, group)
+				}
+			}
+			sort.Strings(entry.Groups)
+		case "description":
+			entry.Description = value
+		case "hidden":
+			entry.Hidden = true
+			if value != "" {
+				entry.Hidden, err = strconv.ParseBool(value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid hidden value: %s", value)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unknown server option: %s", key)
+		}
+	}
+	return entry, nil
+}
+
+// splitServerLine splits a server line at whitespace, quoted values may contain whitespace.
+// Everything after an unquoted # is returned as comment.
+func splitServerLine(line string) (fields []string, comment string, err error) {
+	var (
+		field  strings.Builder
+		quoted bool
+		escape bool
+	)
+	flush := func() error {
+		if field.Len() == 0 {
+			return nil
+		}
+		f := field.String()
+		field.Reset()
+
+		// key="quoted value"
+		if kv := strings.SplitN(f, "=", 2); len(kv) == 2 && strings.HasPrefix(kv[1], `"`) {
+			value, err := strconv.Unquote(kv[1])
+			if err != nil {
+				return fmt.Errorf("invalid quoted value: %s", kv[1])
+			}
+			f = kv[0] + "=" + value
+		}
+		fields = append(fields, f)
+		return nil
+	}
+
+	for idx, r := range line {
+		switch {
+		case quoted:
+			field.WriteRune(r)
+			if escape {
+				escape = false
+			} else if r == '\\' {
+				escape = true
+			} else if r == '"' {
+				quoted = false
+			}
+		case r == '"':
+			quoted = true
+			field.WriteRune(r)
+		case r == '#':
+			return fields, line[idx:], flush()
+		case unicode.IsSpace(r):
+			if err := flush(); err != nil {
+				return nil, "", err
+			}
+		default:
+			field.WriteRune(r)
+		}
+	}
+	if quoted {
+		return nil, "", errors.New("missing closing quote")
+	}
+	return fields, "", flush()
+}
+
+// formatServerLine formats the entry the way it is parsed by parseServerLine
+func formatServerLine(e *ServerEntry) string {
+	sb := strings.Builder{}
+	sb.WriteString(e.String())
+	if e.Alias != "" {
+		sb.WriteString(" alias=" + e.Alias)
+	}
+	if len(e.Groups) > 0 {
+		sb.WriteString(" group=" + strings.Join(e.Groups, ","))
+	}
+	if e.Description != "" {
+		sb.WriteString(" description=" + strconv.Quote(e.Description))
+	}
+	if e.Hidden {
+		sb.WriteString(" hidden")
+	}
+	return sb.String()
+}
+
+// readServerFileLines returns all lines of the server file, a missing file has no lines.
+func readServerFileLines(filePath string) ([]string, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	text := strings.TrimRight(strings.Replace(string(data), "\r\n", "\n", -1), "\n")
+	if text == "" {
+		return nil, nil
+	}
+	return strings.Split(text, "\n"), nil
+}
+
+// autosaveServerList saves the guild's server list after it was changed, if autosaving is enabled.
+func autosaveServerList(s *discordgo.Session, m *discordgo.MessageCreate) {
+	if !config.AutoSave {
+		return
+	}
+
+	err := saveServerFile(config.Guilds.ServerFile(m.GuildID), config.Guilds.ServerList(m.GuildID))
+	if err != nil {
+		log.Printf("failed to save the server list: %v\n", err)
+		respond(s, m, "Failed to save the server list to file.")
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	// discord does not allow for more autocompletion choices
+	maxAutocompleteChoices = 25
+)
+
+var (
+	slashCommands = []*discordgo.ApplicationCommand{
+		{
+			Name:        "help",
+			Description: "Show the available commands.",
+		},
+		{
+			Name:        "online",
+			Description: "List all registered servers that have players playing.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionString,
+					Name:         "gametype",
+					Description:  "Only show servers with this gametype or group.",
+					Autocomplete: true,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionBoolean,
+					Name:        "full",
+					Description: "Show the scores of the players and list the spectators separately.",
+				},
+			},
+		},
+		{
+			Name:        "servers",
+			Description: "Show all servers that are currently registered.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "group",
+					Description: "Only show servers of this group.",
+				},
+			},
+		},
+		{
+			Name:        "add",
+			Description: "Register a new server.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "address",
+					Description: "The server's ip:port or hostname:port, optionally followed by alias=<alias> group=<group>",
+					Required:    true,
+				},
+			},
+		},
+		{
+			Name:        "delete",
+			Description: "Remove a registered server.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionString,
+					Name:         "address",
+					Description:  "The server's address or alias",
+					Required:     true,
+					Autocomplete: true,
+				},
+			},
+		},
+		{
+			Name:        "save",
+			Description: "Save the registered servers to the server file.",
+		},
+		{
+			Name:        "clear",
+			Description: "Remove all registered servers that do not respond.",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionInteger,
+					Name:        "retries",
+					Description: "How often to retry fetching the server infos.",
+				},
+			},
+		},
+	}
+)
+
+// DiscordReadyHandler registers the slash commands once the bot is connected.
+func DiscordReadyHandler(s *discordgo.Session, r *discordgo.Ready) {
+	_, err := s.ApplicationCommandBulkOverwrite(s.State.User.ID, "", slashCommands)
+	if err != nil {
+		log.Printf("failed to register slash commands: %v\n", err)
+	}
+}
+
+// DiscordInteractionCreateHandler handles slash commands, their autocompletion and the buttons of paginated messages.
+func DiscordInteractionCreateHandler(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		SlashCommandHandler(s, i)
+	case discordgo.InteractionApplicationCommandAutocomplete:
+		AutocompleteHandler(s, i)
+	case discordgo.InteractionMessageComponent:
+		PaginationHandler(s, i)
+	}
+}
+
+// SlashCommandHandler executes the handler of a slash command as if it was sent as message.
+func SlashCommandHandler(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.ApplicationCommandData()
+
+	c, ok := config.Commands.Lookup(data.Name)
+	if !ok {
+		return
+	}
+
+	m := interactionMessageCreate(i)
+	reply := newInteractionReply(s, m, i.Interaction)
+	defer reply.finish(s, m)
+
+	c.Handle(s, m, slashCommandArguments(data.Options))
+}
+
+// AutocompleteHandler suggests gametypes and registered server addresses.
+func AutocompleteHandler(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.ApplicationCommandData()
+
+	var (
+		focused    string
+		candidates []string
+	)
+	for _, option := range data.Options {
+		if !option.Focused {
+			continue
+		}
+		focused = strings.ToLower(option.StringValue())
+
+		switch option.Name {
+		case "gametype":
+			candidates = cachedGameTypes()
+		case "address":
+			for _, entry := range config.Guilds.ServerList(i.GuildID).Entries() {
+				candidates = append(candidates, entry.Label())
+			}
+		}
+	}
+
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, 0, maxAutocompleteChoices)
+	for _, candidate := range candidates {
+		if len(choices) == maxAutocompleteChoices {
+			break
+		}
+		if strings.Contains(strings.ToLower(candidate), focused) {
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: candidate, Value: candidate})
+		}
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{Choices: choices},
+	})
+	if err != nil {
+		log.Printf("failed to respond with autocompletion choices: %v\n", err)
+	}
+}
+
+// interactionMessageCreate creates a message event that contains the same
+// author, channel and guild information as the interaction.
+func interactionMessageCreate(i *discordgo.InteractionCreate) *discordgo.MessageCreate {
+	author := i.User
+	if i.Member != nil {
+		author = i.Member.User
+	}
+
+	return &discordgo.MessageCreate{
+		Message: &discordgo.Message{
+			ChannelID: i.ChannelID,
+			GuildID:   i.GuildID,
+			Author:    author,
+			Member:    i.Member,
+		},
+	}
+}
+
+// slashCommandArguments joins the option values in the order they were defined in
+func slashCommandArguments(options []*discordgo.ApplicationCommandInteractionDataOption) string {
+	args := make([]string, 0, len(options))
+	for _, option := range options {
+		switch option.Type {
+		case discordgo.ApplicationCommandOptionInteger:
+			args = append(args, strconv.FormatInt(option.IntValue(), 10))
+		case discordgo.ApplicationCommandOptionBoolean:
+			// boolean options are passed as flags, e.g. -full
+			if option.BoolValue() {
+				args = append(args, "-"+option.Name)
+			}
+		default:
+			args = append(args, option.StringValue())
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+// cachedGameTypes returns all distinct lowercase gametypes of the cached servers
+func cachedGameTypes() []string {
+	infos, _ := config.ServerInfos.Snapshot()
+
+	set := make(map[string]bool, len(infos))
+	for _, info := range infos {
+		if info.GameType != "" {
+			set[strings.ToLower(info.GameType)] = true
+		}
+	}
+
+	gametypes := make([]string, 0, len(set))
+	for gametype := range set {
+		gametypes = append(gametypes, gametype)
+	}
+	sort.Strings(gametypes)
+	return gametypes
+}
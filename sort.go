@@ -23,3 +23,14 @@ type byAddress []*net.UDPAddr
 func (a byAddress) Len() int           { return len(a) }
 func (a byAddress) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a byAddress) Less(i, j int) bool { return a[i].String() < a[j].String() }
+
+type byScoreDescending []browser.PlayerInfo
+
+func (a byScoreDescending) Len() int      { return len(a) }
+func (a byScoreDescending) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byScoreDescending) Less(i, j int) bool {
+	if a[i].Score == a[j].Score {
+		return a[i].Name < a[j].Name
+	}
+	return a[i].Score > a[j].Score
+}
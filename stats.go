@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jxsl13/twapi/browser"
+)
+
+const (
+	// statsDir contains one file per day with the player counts of all servers
+	statsDir = "stats"
+
+	// the polls of a server are aggregated into one sample per statsBucketSize
+	statsBucketSize = 5 * time.Minute
+	statsRetention  = 31 * 24 * time.Hour
+)
+
+var (
+	statsRanges = []struct {
+		names    []string
+		duration time.Duration
+	}{
+		{[]string{"24h", "day"}, 24 * time.Hour},
+		{[]string{"7d", "week"}, 7 * 24 * time.Hour},
+		{[]string{"30d", "month"}, 30 * 24 * time.Hour},
+	}
+)
+
+// NewPlayerCountHistory creates a store that appends the samples to daily files in the data directory
+func NewPlayerCountHistory() *PlayerCountHistory {
+	return &PlayerCountHistory{buckets: make(map[string]*playerCountBucket)}
+}
+
+// PlayerCountHistory stores the number of clients of every polled server over time
+type PlayerCountHistory struct {
+	sync.Mutex
+	// the bucket that is currently aggregated
+	start   time.Time
+	buckets map[string]*playerCountBucket
+}
+
+type playerCountBucket struct {
+	sum   int
+	count int
+	max   int
+}
+
+// PlayerCountSample is the number of clients of a server within one bucket
+type PlayerCountSample struct {
+	Time    time.Time
+	Address string
+	Average float64
+	Max     int
+}
+
+// Record adds the number of clients of all reachable servers to the current bucket
+// and writes the previous bucket to disk once a new bucket starts.
+func (h *PlayerCountHistory) Record(infos []browser.ServerInfo, updatedAt time.Time) {
+	h.Lock()
+	defer h.Unlock()
+
+	start := updatedAt.Truncate(statsBucketSize)
+	if !h.start.Equal(start) {
+		err := h.flush()
+		if err != nil {
+			log.Printf("failed to save the player counts: %v\n", err)
+		}
+		h.start = start
+	}
+
+	for _, info := range infos {
+		if info.Name == "" {
+			continue
+		}
+
+		bucket, ok := h.buckets[info.Address]
+		if !ok {
+			bucket = &playerCountBucket{}
+			h.buckets[info.Address] = bucket
+		}
+		bucket.sum += info.NumClients
+		bucket.count++
+		if info.NumClients > bucket.max {
+			bucket.max = info.NumClients
+		}
+	}
+}
+
+// Flush writes the current bucket to disk, e.g. before the bot shuts down
+func (h *PlayerCountHistory) Flush() error {
+	h.Lock()
+	defer h.Unlock()
+
+	return h.flush()
+}
+
+// flush must be called while holding the lock
+func (h *PlayerCountHistory) flush() error {
+	if len(h.buckets) == 0 {
+		return nil
+	}
+
+	addresses := make([]string, 0, len(h.buckets))
+	for address := range h.buckets {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	sb := strings.Builder{}
+	for _, address := range addresses {
+		bucket := h.buckets[address]
+		average := float64(bucket.sum) / float64(bucket.count)
+		sb.WriteString(fmt.Sprintf("%d,%s,%.2f,%d\n", h.start.Unix(), address, average, bucket.max))
+	}
+	h.buckets = make(map[string]*playerCountBucket)
+
+	dir := filepath.Join(config.DataDir, statsDir)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(statsFile(h.start), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(sb.String())
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return removeExpiredStats(dir, h.start.Add(-statsRetention))
+}
+
+// statsFile returns the file that contains the samples of the day of t
+func statsFile(t time.Time) string {
+	return filepath.Join(config.DataDir, statsDir, t.UTC().Format("2006-01-02")+".csv")
+}
+
+// removeExpiredStats deletes the files of the days before the given time
+func removeExpiredStats(dir string, before time.Time) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	oldest := filepath.Base(statsFile(before))
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".csv") && file.Name() < oldest {
+			err = os.Remove(filepath.Join(dir, file.Name()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Query returns the samples of the given servers within [from, to) in chronological order.
+// The files are only appended to, reading them does not need the lock of the current bucket.
+func (h *PlayerCountHistory) Query(addresses map[string]bool, from, to time.Time) ([]PlayerCountSample, error) {
+	samples := make([]PlayerCountSample, 0, 512)
+	for day := from.UTC().Truncate(24 * time.Hour); day.Before(to); day = day.Add(24 * time.Hour) {
+		data, err := ioutil.ReadFile(statsFile(day))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		for sc.Scan() {
+			sample, err := parseStatsLine(sc.Text())
+			if err != nil {
+				continue
+			}
+			if addresses[sample.Address] && !sample.Time.Before(from) && sample.Time.Before(to) {
+				samples = append(samples, sample)
+			}
+		}
+	}
+
+	sort.SliceStable(samples, func(i, j int) bool {
+		if !samples[i].Time.Equal(samples[j].Time) {
+			return samples[i].Time.Before(samples[j].Time)
+		}
+		return samples[i].Address < samples[j].Address
+	})
+	return mergeSamples(samples), nil
+}
+
+// mergeSamples averages the samples of a server that were saved more than once for the same bucket,
+// which happens if the bot is restarted within a bucket. The samples must be sorted by time and address.
+func mergeSamples(samples []PlayerCountSample) []PlayerCountSample {
+	merged := samples[:0]
+	count := 0
+	for _, sample := range samples {
+		last := len(merged) - 1
+		if last < 0 || !merged[last].Time.Equal(sample.Time) || merged[last].Address != sample.Address {
+			merged = append(merged, sample)
+			count = 1
+			continue
+		}
+
+		merged[last].Average = (merged[last].Average*float64(count) + sample.Average) / float64(count+1)
+		count++
+		if sample.Max > merged[last].Max {
+			merged[last].Max = sample.Max
+		}
+	}
+	return merged
+}
+
+// parseStatsLine parses a line of unix time,ip:port,average,max
+func parseStatsLine(line string) (sample PlayerCountSample, err error) {
+	fields := strings.Split(line, ",")
+	if len(fields) != 4 {
+		return sample, fmt.Errorf("invalid line: %s", line)
+	}
+
+	unix, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return sample, err
+	}
+	sample.Average, err = strconv.ParseFloat(fields[2], 64)
+	if err != nil {
+		return sample, err
+	}
+	sample.Max, err = strconv.Atoi(fields[3])
+	if err != nil {
+		return sample, err
+	}
+	sample.Time = time.Unix(unix, 0).UTC()
+	sample.Address = fields[1]
+	return sample, nil
+}
+
+// sumSamples adds up the averages of all servers per bucket
+func sumSamples(samples []PlayerCountSample) []chartPoint {
+	points := make([]chartPoint, 0, len(samples))
+	for _, sample := range samples {
+		if len(points) > 0 && points[len(points)-1].Time.Equal(sample.Time) {
+			points[len(points)-1].Value += sample.Average
+			continue
+		}
+		points = append(points, chartPoint{Time: sample.Time, Value: sample.Average})
+	}
+	return points
+}
+
+// peakHours returns the hours of the day (UTC) with the most players on average, best first
+func peakHours(points []chartPoint, n int) (hours []int, averages []float64) {
+	var (
+		sums   [24]float64
+		counts [24]int
+	)
+	for _, p := range points {
+		sums[p.Time.Hour()] += p.Value
+		counts[p.Time.Hour()]++
+	}
+
+	for hour := 0; hour < 24; hour++ {
+		if counts[hour] > 0 {
+			hours = append(hours, hour)
+		}
+	}
+	average := func(hour int) float64 { return sums[hour] / float64(counts[hour]) }
+	sort.SliceStable(hours, func(i, j int) bool { return average(hours[i]) > average(hours[j]) })
+
+	if len(hours) > n {
+		hours = hours[:n]
+	}
+	for _, hour := range hours {
+		averages = append(averages, average(hour))
+	}
+	return hours, averages
+}
+
+// dailyAverages returns the average number of players per day (UTC) in chronological order
+func dailyAverages(points []chartPoint) (days []time.Time, averages []float64) {
+	var (
+		sum   float64
+		count int
+	)
+	for idx, p := range points {
+		sum += p.Value
+		count++
+
+		day := p.Time.Truncate(24 * time.Hour)
+		if idx+1 == len(points) || !points[idx+1].Time.Truncate(24*time.Hour).Equal(day) {
+			days = append(days, day)
+			averages = append(averages, sum/float64(count))
+			sum, count = 0, 0
+		}
+	}
+	return days, averages
+}
+
+// parseStatsArgs splits the arguments of !stats into the server and the range
+func parseStatsArgs(args string) (server string, duration time.Duration, name string) {
+	duration, name = statsRanges[0].duration, statsRanges[0].names[0]
+
+	servers := make([]string, 0, 1)
+	for _, field := range strings.Fields(args) {
+		matched := false
+		for _, r := range statsRanges {
+			for _, n := range r.names {
+				if strings.EqualFold(field, n) {
+					duration, name, matched = r.duration, r.names[0], true
+				}
+			}
+		}
+		if !matched {
+			servers = append(servers, field)
+		}
+	}
+	return strings.Join(servers, " "), duration, name
+}
+
+// StatsHandler handles the !stats command that renders the player count history as chart
+func StatsHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
+	server, duration, rangeName := parseStatsArgs(args)
+	list := config.Guilds.ServerList(m.GuildID)
+
+	title := "all servers"
+	addresses := make(map[string]bool)
+	if server != "" {
+		entry, ok := list.Lookup(server)
+		if !ok {
+			respond(s, m, "server is not registered.")
+			return
+		}
+		title = entry.Label()
+		for _, addr := range entry.Addrs {
+			addresses[addr.String()] = true
+		}
+	} else {
+		for _, entry := range list.Entries() {
+			if entry.Hidden {
+				continue
+			}
+			for _, addr := range entry.Addrs {
+				addresses[addr.String()] = true
+			}
+		}
+	}
+
+	to := time.Now().UTC()
+	from := to.Add(-duration)
+	samples, err := config.PlayerCounts.Query(addresses, from, to)
+	if err != nil {
+		log.Printf("failed to read the player counts: %v\n", err)
+		respond(s, m, "Failed to read the player counts.")
+		return
+	}
+
+	points := sumSamples(samples)
+	if len(points) == 0 {
+		respond(s, m, "there is no player count history yet, please try again later.")
+		return
+	}
+
+	chart, err := renderLineChart(fmt.Sprintf("Players on %s, last %s (UTC)", title, rangeName), points, from, to, statsBucketSize)
+	if err != nil {
+		log.Printf("failed to render the player count chart: %v\n", err)
+		respond(s, m, "Failed to render the chart.")
+		return
+	}
+
+	w := NewOutputWriter()
+	w.WriteLine(fmt.Sprintf("**Players on %s, last %s**", Escape(title), rangeName))
+
+	hours, hourAverages := peakHours(points, 3)
+	peaks := make([]string, 0, len(hours))
+	for idx, hour := range hours {
+		peaks = append(peaks, fmt.Sprintf("%02d:00 (%.1f)", hour, hourAverages[idx]))
+	}
+	w.WriteLine(fmt.Sprintf("Peak hours (UTC): %s", strings.Join(peaks, ", ")))
+
+	days, dayAverages := dailyAverages(points)
+	if len(days) > 1 {
+		w.WriteLine("Daily averages:")
+		for idx, day := range days {
+			w.WriteLine(fmt.Sprintf("%s: %.1f", day.Format("Mon Jan 02"), dayAverages[idx]))
+		}
+	}
+
+	w.Attach(&discordgo.File{Name: "stats.png", ContentType: "image/png", Reader: bytes.NewReader(chart)})
+	w.Send(s, m)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jxsl13/twapi/browser"
+)
+
+func TestPlayerCountHistoryMergesRestartedBuckets(t *testing.T) {
+	_, restore := withTestConfig(t, "")
+	defer restore()
+
+	start := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	first := browser.ServerInfo{Address: "1.1.1.1:8303", Name: "first", NumClients: 4}
+	second := browser.ServerInfo{Address: "2.2.2.2:8303", Name: "second", NumClients: 1}
+
+	h := NewPlayerCountHistory()
+	h.Record([]browser.ServerInfo{first, second}, start.Add(time.Minute))
+	err := h.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the bot is restarted within the same bucket
+	first.NumClients = 8
+	h = NewPlayerCountHistory()
+	h.Record([]browser.ServerInfo{first}, start.Add(3*time.Minute))
+	err = h.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addresses := map[string]bool{first.Address: true, second.Address: true}
+	samples, err := h.Query(addresses, start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 2 {
+		t.Fatalf("expected one sample per server, got %v", samples)
+	}
+	if samples[0].Address != first.Address || samples[0].Average != 6 || samples[0].Max != 8 {
+		t.Errorf("expected the samples of the first server to be merged, got %v", samples[0])
+	}
+	if samples[1].Address != second.Address || samples[1].Average != 1 {
+		t.Errorf("expected the second server to be unchanged, got %v", samples[1])
+	}
+
+	points := sumSamples(samples)
+	if len(points) != 1 || points[0].Value != 7 {
+		t.Errorf("expected the restart not to add up the first server twice, got %v", points)
+	}
+}
+
+func TestPlayerCountHistoryQueryDoesNotBlockRecord(t *testing.T) {
+	_, restore := withTestConfig(t, "")
+	defer restore()
+
+	h := NewPlayerCountHistory()
+	h.Lock()
+	defer h.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := h.Query(map[string]bool{}, time.Now().Add(-30*24*time.Hour), time.Now())
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected Query not to wait for the lock of the current bucket")
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jxsl13/twapi/browser"
+)
+
+const (
+	statusBoardFile = "statusboard.json"
+)
+
+// LoadStatusBoards reads the status boards' channel and message IDs from the data directory
+func LoadStatusBoards() (*StatusBoards, error) {
+	boards := &StatusBoards{Boards: make(map[string]*StatusBoard)}
+	err := loadJSON(statusBoardFile, boards)
+	if err != nil {
+		return nil, err
+	}
+	if boards.Boards == nil {
+		boards.Boards = make(map[string]*StatusBoard)
+	}
+
+	// there used to be a single status board
+	if boards.ChannelID != "" {
+		boards.Boards[config.Guilds.key("")] = &StatusBoard{ChannelID: boards.ChannelID, MessageIDs: boards.MessageIDs}
+		boards.ChannelID = ""
+		boards.MessageIDs = nil
+		boards.save()
+	}
+	return boards, nil
+}
+
+// StatusBoards maps guild IDs to their status board
+type StatusBoards struct {
+	sync.Mutex
+	Boards map[string]*StatusBoard `json:"boards"`
+
+	// legacy single status board
+	ChannelID  string   `json:"channel_id,omitempty"`
+	MessageIDs []string `json:"message_ids,omitempty"`
+}
+
+// save must be called while holding the lock
+func (sb *StatusBoards) save() {
+	err := saveJSON(statusBoardFile, sb)
+	if err != nil {
+		log.Printf("failed to save status boards: %v\n", err)
+	}
+}
+
+// Move removes the guild's status board messages from the current channel and
+// posts them into channelID. An empty channelID disables the status board.
+func (sb *StatusBoards) Move(s *discordgo.Session, guildID, channelID string) {
+	sb.Lock()
+	defer sb.Unlock()
+
+	if board, ok := sb.Boards[guildID]; ok {
+		deleteMessages(s, board.ChannelID, board.MessageIDs)
+		delete(sb.Boards, guildID)
+	}
+
+	if channelID != "" {
+		sb.Boards[guildID] = &StatusBoard{ChannelID: channelID}
+	}
+	sb.save()
+}
+
+// Refresh edits the status board messages of all guilds
+func (sb *StatusBoards) Refresh(s *discordgo.Session, infos []browser.ServerInfo, updatedAt time.Time) {
+	sb.Lock()
+	defer sb.Unlock()
+
+	changed := false
+	for guildID, board := range sb.Boards {
+		list := config.Guilds.ServerList(guildID)
+		guildInfos := visibleServerInfos(list, guildServerInfos(guildID, infos), "")
+		gametype := config.Guilds.GameTypeFilter(guildID)
+		if board.Refresh(s, list, guildInfos, updatedAt, gametype) {
+			changed = true
+		}
+	}
+
+	if changed {
+		sb.save()
+	}
+}
+
+// StatusBoard is a set of messages in one channel that is edited in place
+// with the currently online servers after every poll.
+type StatusBoard struct {
+	ChannelID  string   `json:"channel_id"`
+	MessageIDs []string `json:"message_ids"`
+}
+
+// Refresh edits the status board messages to show the given server infos.
+// Deleted messages are recreated, additional messages are sent or surplus ones deleted
+// if the number of required messages changed. changed is true if the message IDs changed.
+func (b *StatusBoard) Refresh(s *discordgo.Session, list *ConcurrentServerList, infos []browser.ServerInfo, updatedAt time.Time, gametype string) (changed bool) {
+	contents := onlineMessages(list, infos, updatedAt, gametype, false)
+	messageIDs := make([]string, 0, len(contents))
+
+	for idx, content := range contents {
+		if idx < len(b.MessageIDs) {
+			_, err := s.ChannelMessageEdit(b.ChannelID, b.MessageIDs[idx], content)
+			if err == nil {
+				messageIDs = append(messageIDs, b.MessageIDs[idx])
+				continue
+			}
+
+			if !isUnknownMessageError(err) {
+				log.Printf("failed to edit status board message: %v\n", err)
+				messageIDs = append(messageIDs, b.MessageIDs[idx])
+				continue
+			}
+
+			// the message was deleted, all following messages are recreated in order to keep their order.
+			deleteMessages(s, b.ChannelID, b.MessageIDs[idx+1:])
+			b.MessageIDs = b.MessageIDs[:idx]
+		}
+
+		msg, err := s.ChannelMessageSend(b.ChannelID, content)
+		if err != nil {
+			log.Printf("failed to send status board message: %v\n", err)
+			break
+		}
+
+		if idx == 0 {
+			err = s.ChannelMessagePin(b.ChannelID, msg.ID)
+			if err != nil {
+				log.Printf("failed to pin status board message: %v\n", err)
+			}
+		}
+		messageIDs = append(messageIDs, msg.ID)
+	}
+
+	if len(b.MessageIDs) > len(contents) {
+		deleteMessages(s, b.ChannelID, b.MessageIDs[len(contents):])
+	}
+
+	changed = strings.Join(messageIDs, ",") != strings.Join(b.MessageIDs, ",")
+	b.MessageIDs = messageIDs
+	return changed
+}
+
+// StatusBoardHandler handles the !statusboard command that moves the status board into a channel or disables it.
+func StatusBoardHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
+	channelID, err := mentionedChannel(s, m, strings.TrimSpace(args))
+	if err != nil {
+		respond(s, m, err.Error())
+		return
+	}
+	if channelID == "" {
+		config.StatusBoards.Move(s, m.GuildID, "")
+		respond(s, m, "Status board disabled.")
+		return
+	}
+
+	config.StatusBoards.Move(s, m.GuildID, channelID)
+
+	infos, updatedAt := config.ServerInfos.Snapshot()
+	if !updatedAt.IsZero() {
+		config.StatusBoards.Refresh(s, infos, updatedAt)
+	}
+	respond(s, m, fmt.Sprintf("Status board moved to <#%s>.", channelID))
+}
+
+func deleteMessages(s *discordgo.Session, channelID string, messageIDs []string) {
+	for _, messageID := range messageIDs {
+		err := s.ChannelMessageDelete(channelID, messageID)
+		if err != nil && !isUnknownMessageError(err) {
+			log.Printf("failed to delete message %s: %v\n", messageID, err)
+		}
+	}
+}
+
+func isUnknownMessageError(err error) bool {
+	restErr, ok := err.(*discordgo.RESTError)
+	return ok && restErr.Message != nil && restErr.Message.Code == discordgo.ErrCodeUnknownMessage
+}
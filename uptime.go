@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jxsl13/twapi/browser"
+)
+
+const (
+	uptimeFile = "uptime.json"
+
+	// polls are aggregated into buckets, the history covers the longest shown period
+	uptimeBucketSize = 10 * time.Minute
+	uptimeHistory    = 7 * 24 * time.Hour
+
+	// the history is written to disk at most once per uptimeSaveInterval
+	uptimeSaveInterval = 5 * time.Minute
+)
+
+var (
+	uptimePeriods = []struct {
+		name     string
+		duration time.Duration
+	}{
+		{"1h", time.Hour},
+		{"24h", 24 * time.Hour},
+		{"7d", 7 * 24 * time.Hour},
+	}
+)
+
+// LoadUptimeTracker reads the reachability history from the data directory
+func LoadUptimeTracker() (*UptimeTracker, error) {
+	tracker := &UptimeTracker{Servers: make(map[string]*ServerUptime)}
+	err := loadJSON(uptimeFile, tracker)
+	if err != nil {
+		return nil, err
+	}
+	if tracker.Servers == nil {
+		tracker.Servers = make(map[string]*ServerUptime)
+	}
+	tracker.savedAt = time.Now()
+	return tracker, nil
+}
+
+// UptimeTracker records how reliably the polled servers respond, keyed by their resolved ip:port
+type UptimeTracker struct {
+	sync.Mutex
+	Servers map[string]*ServerUptime `json:"servers"`
+
+	savedAt time.Time
+}
+
+// ServerUptime is the reachability history of a single server
+type ServerUptime struct {
+	LastSeen time.Time      `json:"last_seen,omitempty"`
+	Buckets  []uptimeBucket `json:"buckets"`
+}
+
+// uptimeBucket aggregates the polls of one uptimeBucketSize interval
+type uptimeBucket struct {
+	Start     int64 `json:"t"`
+	Polls     int   `json:"n"`
+	Successes int   `json:"ok"`
+	// LatencyMs is the sum of the response times of the successful polls
+	LatencyMs int64 `json:"ms"`
+}
+
+// UptimeStats is the availability of a server within a period
+type UptimeStats struct {
+	Polls     int
+	Successes int
+	Latency   time.Duration
+}
+
+// Availability returns the percentage of successful polls
+func (s *UptimeStats) Availability() float64 {
+	if s.Polls == 0 {
+		return 0
+	}
+	return 100 * float64(s.Successes) / float64(s.Polls)
+}
+
+// save must be called while holding the lock
+func (ut *UptimeTracker) save() error {
+	ut.savedAt = time.Now()
+	return saveJSON(uptimeFile, ut)
+}
+
+// Record adds a poll of all servers, servers without a fetched info count as unreachable.
+func (ut *UptimeTracker) Record(servers []*net.UDPAddr, infos []browser.ServerInfo, latencies map[string]time.Duration, now time.Time) {
+	reachable := make(map[string]bool, len(infos))
+	for _, info := range infos {
+		if info.Name != "" {
+			reachable[info.Address] = true
+		}
+	}
+
+	ut.Lock()
+	defer ut.Unlock()
+
+	start := now.Truncate(uptimeBucketSize).Unix()
+	oldest := now.Add(-uptimeHistory).Truncate(uptimeBucketSize).Unix()
+
+	for _, srv := range servers {
+		address := srv.String()
+		su, ok := ut.Servers[address]
+		if !ok {
+			su = &ServerUptime{}
+			ut.Servers[address] = su
+		}
+
+		if len(su.Buckets) == 0 || su.Buckets[len(su.Buckets)-1].Start != start {
+			su.Buckets = append(su.Buckets, uptimeBucket{Start: start})
+		}
+		bucket := &su.Buckets[len(su.Buckets)-1]
+		bucket.Polls++
+
+		if reachable[address] {
+			bucket.Successes++
+			bucket.LatencyMs += latencies[address].Milliseconds()
+			su.LastSeen = now
+		}
+	}
+
+	// forget old buckets and servers that were not polled for the whole history
+	for address, su := range ut.Servers {
+		idx := 0
+		for idx < len(su.Buckets) && su.Buckets[idx].Start < oldest {
+			idx++
+		}
+		su.Buckets = su.Buckets[idx:]
+
+		if len(su.Buckets) == 0 {
+			delete(ut.Servers, address)
+		}
+	}
+
+	if time.Since(ut.savedAt) >= uptimeSaveInterval {
+		err := ut.save()
+		if err != nil {
+			log.Printf("failed to save the uptime history: %v\n", err)
+		}
+	}
+}
+
+// Flush writes the history to disk, e.g. before the bot shuts down
+func (ut *UptimeTracker) Flush() error {
+	ut.Lock()
+	defer ut.Unlock()
+
+	return ut.save()
+}
+
+// Stats returns the availability of the server within the period and when it was seen the last time.
+// ok is false if the server was never polled.
+func (ut *UptimeTracker) Stats(address string, period time.Duration, now time.Time) (stats UptimeStats, lastSeen time.Time, ok bool) {
+	ut.Lock()
+	defer ut.Unlock()
+
+	su, ok := ut.Servers[address]
+	if !ok {
+		return stats, lastSeen, false
+	}
+
+	since := now.Add(-period).Unix()
+	latencyMs := int64(0)
+	for _, bucket := range su.Buckets {
+		if bucket.Start < since {
+			continue
+		}
+		stats.Polls += bucket.Polls
+		stats.Successes += bucket.Successes
+		latencyMs += bucket.LatencyMs
+	}
+	if stats.Successes > 0 {
+		stats.Latency = time.Duration(latencyMs/int64(stats.Successes)) * time.Millisecond
+	}
+	return stats, su.LastSeen, true
+}
+
+// formatUptime formats the availability of all periods, the last seen time and the average latency of a server
+func formatUptime(address string, now time.Time) string {
+	parts := make([]string, 0, len(uptimePeriods)+2)
+
+	var (
+		lastSeen time.Time
+		latency  time.Duration
+	)
+	for _, period := range uptimePeriods {
+		stats, seen, ok := config.Uptime.Stats(address, period.duration, now)
+		if !ok {
+			return "no data yet"
+		}
+		lastSeen = seen
+
+		if stats.Polls == 0 {
+			parts = append(parts, fmt.Sprintf("%s: n/a", period.name))
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s: **%.1f%%**", period.name, stats.Availability()))
+
+		// the average latency of the longest period
+		latency = stats.Latency
+	}
+
+	if lastSeen.IsZero() {
+		parts = append(parts, "never seen")
+	} else {
+		parts = append(parts, "last seen "+formatAge(lastSeen))
+	}
+	if latency > 0 {
+		parts = append(parts, fmt.Sprintf("avg. response %dms", latency.Milliseconds()))
+	}
+	return strings.Join(parts, " | ")
+}
+
+// UptimeHandler handles the !uptime command that shows the availability of the registered servers
+func UptimeHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
+	list := config.Guilds.ServerList(m.GuildID)
+	argument := strings.TrimSpace(args)
+	now := time.Now()
+
+	entries := list.Entries()
+	if argument != "" {
+		entry, ok := list.Lookup(argument)
+		if !ok {
+			respond(s, m, "server is not registered.")
+			return
+		}
+		entries = []ServerEntry{entry}
+	}
+
+	// the server names are taken from the most recent poll
+	infos, _ := guildSnapshot(m.GuildID)
+	names := make(map[string]string, len(infos))
+	for _, info := range infos {
+		if info.Name != "" {
+			names[info.Address] = info.Name
+		}
+	}
+
+	lines := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Hidden && argument == "" {
+			continue
+		}
+
+		for _, addr := range entry.Addrs {
+			address := addr.String()
+
+			label := entry.Label()
+			if name, ok := names[address]; ok {
+				label = fmt.Sprintf("%s (%s)", name, entry.Label())
+			}
+			if len(entry.Addrs) > 1 {
+				label = fmt.Sprintf("%s [%s]", label, address)
+			}
+			lines = append(lines, fmt.Sprintf("**%s** - %s", Escape(label), formatUptime(address, now)))
+		}
+	}
+
+	if len(lines) == 0 {
+		respond(s, m, "there are no registered servers.")
+		return
+	}
+	sort.Strings(lines)
+
+	w := NewOutputWriter()
+	for _, line := range lines {
+		w.WriteLine(line)
+	}
+	w.Send(s, m)
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jxsl13/twapi/browser"
+)
+
+const (
+	watchlistFile = "watchlist.json"
+
+	// maxWatchesPerUser limits how many player names a single discord user can watch
+	maxWatchesPerUser = 25
+
+	watchModeExact           = "exact"
+	watchModeCaseInsensitive = "nocase"
+	watchModeRegex           = "regex"
+)
+
+// Watch is a subscription of a discord user to a teeworlds player name on the servers of a guild
+type Watch struct {
+	UserID  string `json:"user_id"`
+	GuildID string `json:"guild_id,omitempty"`
+	Pattern string `json:"pattern"`
+	Mode    string `json:"mode"`
+	// SeenOn contains the server addresses the player was seen on in the previous poll,
+	// it is saved in order not to alert the user again after a restart.
+	SeenOn map[string]bool `json:"seen_on,omitempty"`
+
+	regex *regexp.Regexp
+}
+
+// NewWatch creates a new watch and validates its pattern
+func NewWatch(userID, guildID, pattern, mode string) (*Watch, error) {
+	w := &Watch{UserID: userID, GuildID: guildID, Pattern: pattern, Mode: mode}
+	err := w.compile()
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *Watch) compile() (err error) {
+	if w.SeenOn == nil {
+		w.SeenOn = make(map[string]bool)
+	}
+
+	switch w.Mode {
+	case watchModeExact, watchModeCaseInsensitive:
+		return nil
+	case watchModeRegex:
+		w.regex, err = regexp.Compile(w.Pattern)
+		if err != nil {
+			return errors.New("invalid regular expression")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown watch mode: %s", w.Mode)
+	}
+}
+
+// Matches returns true if the player name is matched by the watch
+func (w *Watch) Matches(name string) bool {
+	switch w.Mode {
+	case watchModeExact:
+		return name == w.Pattern
+	case watchModeCaseInsensitive:
+		return strings.EqualFold(name, w.Pattern)
+	case watchModeRegex:
+		return w.regex.MatchString(name)
+	}
+	return false
+}
+
+// String returns a human readable representation of the watch
+func (w *Watch) String() string {
+	return fmt.Sprintf("%s (%s)", WrapInInlineCodeBlock(w.Pattern), w.Mode)
+}
+
+// LoadWatchlist reads all watches from the data directory
+func LoadWatchlist() (*Watchlist, error) {
+	watchlist := &Watchlist{}
+	err := loadJSON(watchlistFile, &watchlist.Watches)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, w := range watchlist.Watches {
+		err = w.compile()
+		if err != nil {
+			return nil, fmt.Errorf("invalid watch %s of user %s: %v", w.Pattern, w.UserID, err)
+		}
+	}
+	return watchlist, nil
+}
+
+// Watchlist contains the player watches of all discord users
+type Watchlist struct {
+	sync.Mutex
+	Watches []*Watch
+}
+
+// save must be called while holding the lock
+func (wl *Watchlist) save() error {
+	return saveJSON(watchlistFile, wl.Watches)
+}
+
+// Add adds a new watch, if the user does not watch the same pattern in the guild already
+func (wl *Watchlist) Add(w *Watch) error {
+	wl.Lock()
+	defer wl.Unlock()
+
+	numWatches := 0
+	for _, watch := range wl.Watches {
+		if watch.UserID != w.UserID {
+			continue
+		}
+		if watch.GuildID == w.GuildID && watch.Pattern == w.Pattern {
+			return errors.New("you are already watching this name")
+		}
+		numWatches++
+	}
+
+	if numWatches >= maxWatchesPerUser {
+		return fmt.Errorf("you cannot watch more than %d names", maxWatchesPerUser)
+	}
+
+	wl.Watches = append(wl.Watches, w)
+	return wl.save()
+}
+
+// Delete removes the watch with the given pattern of a user in the guild
+func (wl *Watchlist) Delete(userID, guildID, pattern string) error {
+	wl.Lock()
+	defer wl.Unlock()
+
+	for idx, watch := range wl.Watches {
+		if watch.UserID == userID && watch.GuildID == guildID && watch.Pattern == pattern {
+			wl.Watches = append(wl.Watches[:idx], wl.Watches[idx+1:]...)
+			return wl.save()
+		}
+	}
+	return errors.New("you are not watching this name")
+}
+
+// UserWatches returns the watches of a single user in the guild
+func (wl *Watchlist) UserWatches(userID, guildID string) (watches []*Watch) {
+	wl.Lock()
+	defer wl.Unlock()
+
+	for _, watch := range wl.Watches {
+		if watch.UserID == userID && watch.GuildID == guildID {
+			watches = append(watches, watch)
+		}
+	}
+	return
+}
+
+// Alert sends a direct message to every user whose watched player name appeared on a server
+// since the previous poll. Only the servers that the guild of the watch lists are watched.
+func (wl *Watchlist) Alert(s *discordgo.Session, infos []browser.ServerInfo, updatedAt time.Time) {
+	wl.Lock()
+	defer wl.Unlock()
+
+	alerts := make(map[string]*strings.Builder)
+	changed := false
+
+	// the visible servers of every guild that has watches
+	guildInfos := make(map[string][]browser.ServerInfo)
+
+	for _, watch := range wl.Watches {
+		list := config.Guilds.ServerList(watch.GuildID)
+		visible, ok := guildInfos[watch.GuildID]
+		if !ok {
+			visible = visibleServerInfos(list, infos, "")
+			guildInfos[watch.GuildID] = visible
+		}
+
+		// forget servers that were removed or hidden
+		registered := make(map[string]bool, len(visible))
+		for _, server := range visible {
+			registered[server.Address] = true
+		}
+		for address := range watch.SeenOn {
+			if !registered[address] {
+				delete(watch.SeenOn, address)
+				changed = true
+			}
+		}
+
+		for _, server := range visible {
+			if server.Name == "" {
+				// unreachable servers keep their previous state
+				continue
+			}
+
+			matched := ""
+			for _, player := range server.Players {
+				if watch.Matches(player.Name) {
+					matched = player.Name
+					break
+				}
+			}
+
+			if matched == "" {
+				if watch.SeenOn[server.Address] {
+					delete(watch.SeenOn, server.Address)
+					changed = true
+				}
+				continue
+			}
+
+			if watch.SeenOn[server.Address] {
+				continue
+			}
+			watch.SeenOn[server.Address] = true
+			changed = true
+
+			sb, ok := alerts[watch.UserID]
+			if !ok {
+				sb = &strings.Builder{}
+				alerts[watch.UserID] = sb
+			}
+			sb.WriteString(fmt.Sprintf("%s is playing on **%s** (%s) Map: **%s**\n", WrapInInlineCodeBlock(matched), Escape(server.Name), Escape(list.DisplayAddress(server.Address)), Escape(server.Map)))
+		}
+	}
+
+	if changed {
+		err := wl.save()
+		if err != nil {
+			log.Printf("failed to save the watchlist: %v\n", err)
+		}
+	}
+
+	for userID, sb := range alerts {
+		channel, err := s.UserChannelCreate(userID)
+		if err != nil {
+			log.Printf("failed to create direct message channel for user %s: %v\n", userID, err)
+			continue
+		}
+
+		w := NewOutputWriter()
+		w.Write(sb.String())
+		for _, msg := range w.Messages() {
+			_, err = s.ChannelMessageSend(channel.ID, msg)
+			if err != nil {
+				log.Printf("failed to send watchlist alert to user %s: %v\n", userID, err)
+				break
+			}
+		}
+	}
+}
+
+// parseWatchArgs parses [-exact|-regex] <name>
+func parseWatchArgs(args string) (pattern, mode string) {
+	args = strings.TrimSpace(args)
+	mode = watchModeCaseInsensitive
+
+	for _, m := range []string{watchModeExact, watchModeRegex} {
+		flag := "-" + m + " "
+		if strings.HasPrefix(args, flag) {
+			return strings.TrimSpace(args[len(flag):]), m
+		}
+	}
+	return args, mode
+}
+
+// WatchHandler handles the !watch command
+func WatchHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
+	pattern, mode := parseWatchArgs(args)
+	if pattern == "" {
+		respond(s, m, usage(m, "watch"))
+		return
+	}
+
+	w, err := NewWatch(m.Author.ID, m.GuildID, pattern, mode)
+	if err != nil {
+		respond(s, m, err.Error())
+		return
+	}
+
+	err = config.Watchlist.Add(w)
+	if err != nil {
+		respond(s, m, err.Error())
+		return
+	}
+	respond(s, m, fmt.Sprintf("Watching %s, you will receive a direct message when the player joins a server.", w.String()))
+}
+
+// UnwatchHandler handles the !unwatch command
+func UnwatchHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
+	pattern, _ := parseWatchArgs(args)
+	if pattern == "" {
+		respond(s, m, usage(m, "unwatch"))
+		return
+	}
+
+	err := config.Watchlist.Delete(m.Author.ID, m.GuildID, pattern)
+	if err != nil {
+		respond(s, m, err.Error())
+		return
+	}
+	respond(s, m, "Unwatched.")
+}
+
+// WatchlistHandler handles the !watchlist command that shows the user's watched names
+func WatchlistHandler(s *discordgo.Session, m *discordgo.MessageCreate, args string) {
+	watches := config.Watchlist.UserWatches(m.Author.ID, m.GuildID)
+	if len(watches) == 0 {
+		respond(s, m, "You are not watching any player names.")
+		return
+	}
+
+	w := NewOutputWriter()
+	w.WriteLine("You are watching:")
+	for _, watch := range watches {
+		w.WriteLine(watch.String())
+	}
+
+	w.Send(s, m)
+}